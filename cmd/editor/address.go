@@ -0,0 +1,365 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+
+	"git.sr.ht/~travgm/ollie/olliefile"
+	"git.sr.ht/~travgm/ollie/search"
+)
+
+// lineRange is the lines [first, last] a command works on, numbered from 1
+// like they are shown. given is false when the command had no addresses and
+// the range is the command's default.
+type lineRange struct {
+	first, last int
+	given       bool
+}
+
+// addressParser reads ed style line addresses from the front of a command.
+// cur is the current line the relative addresses and searches start from, a
+// ';' moves it.
+type addressParser struct {
+	state *State
+	s     string
+	pos   int
+	cur   int
+}
+
+// lazyLastLine is the current line of a file opened while its lines are
+// still being indexed. Like ed the last line is current after opening a
+// file, but which line that is is only worked out once it is needed.
+const lazyLastLine = -1
+
+// dot returns the current line, waiting to find out how many lines
+// the file has if the last line is current and that isn't known yet
+func dot(state *State) int {
+	if state.line == lazyLastLine {
+		state.line = state.ollie.Lines.Len()
+	}
+	return state.line
+}
+
+// current is the line relative addresses start from
+func (p *addressParser) current() int {
+	if p.cur == lazyLastLine {
+		p.cur = dot(p.state)
+	}
+	return p.cur
+}
+
+// last is the number of the last line. Unlike checking an address with
+// hasLine it waits for a mapped file to be indexed to the end.
+func (p *addressParser) last() int {
+	return p.state.ollie.Lines.Len()
+}
+
+// hasLine checks that line can be addressed, 0 is before the first line
+func (p *addressParser) hasLine(line int) bool {
+	return line >= 0 && olliefile.HasLines(p.state.ollie.Lines, line)
+}
+
+// parseAddresses splits the addresses off the front of a command and returns
+// the range they make along with the rest of the command. An address is
+// built from
+//
+//	n      line n
+//	.      the current line
+//	$      the last line
+//	/re/   the next line matching the regular expression re, searching
+//	       forward from the current line and wrapping around to the start
+//	?re?   the same searching backward
+//	+n -n  n lines after or before the current line, or after or before the
+//	       address it follows as in $-2. n is 1 if left out.
+//
+// An empty regular expression, // or ??, is the last one used. Two addresses
+// separated by ',' make a range, with ';' the current line is set to the
+// first one before the second is read. Like ed, ',' alone is 1,$ and ';'
+// alone is .,$. Without addresses the range is just the current line.
+func parseAddresses(state *State, s string) (lineRange, string, error) {
+	p := &addressParser{state: state, s: s, cur: state.line}
+	r := lineRange{first: state.line, last: state.line}
+	n := 0
+	push := func(a int) {
+		r.first, r.last = r.last, a
+		n += 1
+	}
+
+	a, ok, err := p.address()
+	if err != nil {
+		return r, s, err
+	}
+	if ok {
+		push(a)
+	}
+	for p.pos < len(s) && (s[p.pos] == ',' || s[p.pos] == ';') {
+		sep := s[p.pos]
+		p.pos += 1
+
+		if n == 0 {
+			if sep == ',' {
+				push(1)
+			} else {
+				push(p.current())
+			}
+			a, ok, err = p.address()
+			if !ok {
+				a = p.last()
+			}
+		} else {
+			if sep == ';' {
+				p.cur = r.last
+			}
+			a, ok, err = p.address()
+			if !ok {
+				a = r.last
+			}
+		}
+		if err != nil {
+			return r, s, err
+		}
+		push(a)
+	}
+
+	if n == 1 {
+		r.first = r.last
+	}
+	r.given = n > 0
+	if r.given && (r.first < 0 || !p.hasLine(r.last) || r.first > r.last) {
+		return r, s[p.pos:], fmt.Errorf("invalid address %s", s[:p.pos])
+	}
+	return r, s[p.pos:], nil
+}
+
+// address reads a single address, ok is false if there isn't one
+func (p *addressParser) address() (line int, ok bool, err error) {
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == '.':
+			line = p.current()
+			p.pos += 1
+			ok = true
+		case c == '$':
+			line = p.last()
+			p.pos += 1
+			ok = true
+		case c >= '0' && c <= '9':
+			line, err = p.number()
+			if err != nil {
+				return 0, false, err
+			}
+			ok = true
+		case c == '/' || c == '?':
+			line, err = p.search(c)
+			if err != nil {
+				return 0, false, err
+			}
+			ok = true
+		}
+	}
+
+	for p.skipSpaces(); p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-'); p.skipSpaces() {
+		sign := 1
+		if p.s[p.pos] == '-' {
+			sign = -1
+		}
+		p.pos += 1
+		if !ok {
+			line = p.current()
+		}
+		offset := 1
+		if p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+			offset, err = p.number()
+			if err != nil {
+				return 0, false, err
+			}
+		}
+		line += sign * offset
+		ok = true
+	}
+
+	if ok && !p.hasLine(line) {
+		return 0, false, fmt.Errorf("invalid address %s", p.s[:p.pos])
+	}
+	return line, ok, nil
+}
+
+func (p *addressParser) number() (int, error) {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos += 1
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %s", p.s[:p.pos])
+	}
+	return n, nil
+}
+
+// search finds the line matching the pattern between the delimiters
+func (p *addressParser) search(delim byte) (int, error) {
+	pattern, rest, _ := splitDelimited(p.s[p.pos+1:], delim)
+	p.pos = len(p.s) - len(rest)
+	m, err := compilePattern(p.state, pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := p.state.ollie.Lines
+	cur, lines := p.current(), p.last()
+	line := -1
+	if delim == '/' {
+		find := func(i int, s string) bool {
+			if m.MatchString(s) {
+				line = i + 1
+			}
+			return line < 0
+		}
+		buf.Range(cur, lines, find)
+		if line < 0 {
+			buf.Range(0, min(cur, lines), find)
+		}
+	} else {
+		for n := 1; n <= lines && line < 0; n++ {
+			i := ((cur-1-n)%lines + lines) % lines
+			if m.MatchString(buf.Line(i)) {
+				line = i + 1
+			}
+		}
+	}
+	if line < 0 {
+		return 0, fmt.Errorf("no match for %s", m)
+	}
+	return line, nil
+}
+
+// splitDelimited returns s up to the first delim that isn't escaped with a
+// backslash and what follows it. closed is false when there was no delim, in
+// which case everything is taken, the way ed lets the last delimiter be left
+// off.
+func splitDelimited(s string, delim byte) (text, rest string, closed bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i += 1
+		case delim:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// checkDelimiter makes sure c can be used around the regular expression of
+// s, g and v. Like ed that is any character but letters, digits, spaces and
+// backslashes.
+func checkDelimiter(c byte) error {
+	if c == ' ' || c == '\\' || c < 0x20 || c >= 0x7f ||
+		(c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'z') {
+		return fmt.Errorf("invalid delimiter %q", c)
+	}
+	return nil
+}
+
+// compilePattern compiles a regular expression for a command, an empty one
+// is the last one used
+func compilePattern(state *State, pattern string) (*search.Matcher, error) {
+	if pattern == "" {
+		if state.lastPattern == nil {
+			return nil, fmt.Errorf("no previous regular expression")
+		}
+		return state.lastPattern, nil
+	}
+	m, err := search.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	state.lastPattern = m
+	return m, nil
+}
+
+func (p *addressParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos += 1
+	}
+}
+
+// splitCommand splits what follows the addresses into the command name and
+// its parameter. Names are letters, optionally followed by '!' like w!, or
+// '='. The parameter starts right after the name so commands like s/a/b/
+// need no space.
+func splitCommand(s string) (string, string) {
+	end := 0
+	for end < len(s) && s[end] < unicode.MaxASCII && unicode.IsLetter(rune(s[end])) {
+		end += 1
+	}
+	if end > 0 && end < len(s) && s[end] == '!' {
+		end += 1
+	}
+	if end == 0 && len(s) > 0 && s[0] == '=' {
+		end = 1
+	}
+	param := s[end:]
+	for len(param) > 0 && param[0] == ' ' {
+		param = param[1:]
+	}
+	return s[:end], param
+}
+
+// Where the range of a command that takes line addresses is when none are
+// given
+const (
+	currentLine = iota
+	wholeBuffer
+	lastLine
+)
+
+// addressing is how a command takes line addresses
+type addressing struct {
+	def  int  // the range without addresses
+	zero bool // line 0 is allowed and means before the first line
+}
+
+// resolve fills in the default range for r and checks it for the command
+func (a addressing) resolve(r lineRange, state *State) (lineRange, error) {
+	if !r.given {
+		switch a.def {
+		case currentLine:
+			cur := dot(state)
+			r.first, r.last = cur, cur
+		case wholeBuffer:
+			r.first, r.last = 1, state.ollie.Lines.Len()
+			// Writing an empty buffer is fine, it has no lines to address
+			return r, nil
+		case lastLine:
+			lines := state.ollie.Lines.Len()
+			r.first, r.last = lines, lines
+		}
+	}
+	if r.first < 1 && !(a.zero && r.first == 0) {
+		return r, fmt.Errorf("invalid address")
+	}
+	return r, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"git.sr.ht/~travgm/ollie/olliefile"
+)
+
+// testState has a buffer of ten lines "line 1" to "line 10" with line 5 the
+// current one
+func testState() *State {
+	of := olliefile.NewFile("test.txt")
+	for i := 1; i <= 10; i++ {
+		of.AppendLine(fmt.Sprintf("line %d", i))
+	}
+	return &State{ollie: of, line: 5}
+}
+
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last int
+		given       bool
+		rest        string
+	}{
+		{"p", 5, 5, false, "p"},
+		{"3,7d", 3, 7, true, "d"},
+		{".,$p", 5, 10, true, "p"},
+		{"-2,+2n", 3, 7, true, "n"},
+		{"$-1", 9, 9, true, ""},
+		{"--", 3, 3, true, ""},
+		{",p", 1, 10, true, "p"},
+		{";p", 5, 10, true, "p"},
+		{",3", 1, 3, true, ""},
+		{"3,", 3, 3, true, ""},
+		{"2;+1", 2, 3, true, ""},
+		{"2,+1", 2, 6, true, ""},
+		{"0r x", 0, 0, true, "r x"},
+		{"1, 4 w out", 1, 4, true, "w out"},
+		{"/line 1/p", 10, 10, true, "p"},
+		{"/line [0-9]$/", 6, 6, true, ""},
+		{"?line 1?", 1, 1, true, ""},
+		{"?9?", 9, 9, true, ""},
+		{"/4/,/6/d", 4, 6, true, "d"},
+		{"/3/;//", 3, 3, true, ""},
+		{"/3/+1", 4, 4, true, ""},
+	}
+	for _, tt := range tests {
+		r, rest, err := parseAddresses(testState(), tt.in)
+		if err != nil {
+			t.Errorf("parseAddresses(%q) = %v", tt.in, err)
+			continue
+		}
+		if r.first != tt.first || r.last != tt.last || r.given != tt.given || rest != tt.rest {
+			t.Errorf("parseAddresses(%q) = %d,%d given %v rest %q, want %d,%d given %v rest %q",
+				tt.in, r.first, r.last, r.given, rest, tt.first, tt.last, tt.given, tt.rest)
+		}
+	}
+
+	for _, in := range []string{"11p", "7,3p", "+6", "-6", "/nope/", "//", "/[/"} {
+		if _, _, err := parseAddresses(testState(), in); err == nil {
+			t.Errorf("parseAddresses(%q) succeeded", in)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct{ in, cmd, param string }{
+		{"w! out.txt", "w!", "out.txt"},
+		{"s/a/b/g", "s", "/a/b/g"},
+		{"r !ls -l", "r", "!ls -l"},
+		{"=", "=", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		cmd, param := splitCommand(tt.in)
+		if cmd != tt.cmd || param != tt.param {
+			t.Errorf("splitCommand(%q) = %q, %q, want %q, %q", tt.in, cmd, param, tt.cmd, tt.param)
+		}
+	}
+}
+
+func TestSplitDelimited(t *testing.T) {
+	tests := []struct {
+		in, text, rest string
+		closed         bool
+	}{
+		{"a/b", "a", "b", true},
+		{"a\\/b/p", "a\\/b", "p", true},
+		{"abc", "abc", "", false},
+	}
+	for _, tt := range tests {
+		text, rest, closed := splitDelimited(tt.in, '/')
+		if text != tt.text || rest != tt.rest || closed != tt.closed {
+			t.Errorf("splitDelimited(%q) = %q, %q, %v", tt.in, text, rest, closed)
+		}
+	}
+}
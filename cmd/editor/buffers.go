@@ -0,0 +1,245 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"git.sr.ht/~travgm/ollie/olliefile"
+)
+
+// A file open in the editor along with the editor state that belongs to it.
+// The buffer being edited lives in State itself, the others keep their
+// state here until they are switched back to.
+type buffer struct {
+	file       *olliefile.File
+	line       int
+	spellcheck bool
+}
+
+// Name of the buffer ollie starts with when it isn't given a file
+const scratchName = "junk.ollie"
+
+// Make an empty buffer for the named file with the settings from the flags
+// and the config file
+func newFile(state *State, name string) (*olliefile.File, error) {
+	of := olliefile.NewFile(name)
+	of.MaxLineLength = state.maxLine
+	of.MapThreshold = olliefile.DefaultMapThreshold
+	of.Swap = true
+	of.Lock = true
+	of.ReadOnly = state.readOnly
+	if state.passphrase != "" {
+		of.SetPassphrase(state.passphrase)
+	}
+	err := applyBackupConfig(of, state.conf)
+	if err != nil {
+		return nil, err
+	}
+	return of, nil
+}
+
+// Load the named file into a new buffer and switch to it
+func openBuffer(state *State, name string) error {
+	for i, b := range state.buffers {
+		if b.file.Name == name {
+			switchBuffer(state, i)
+			fmt.Printf("%s is already open in buffer %d\n", name, i+1)
+			return nil
+		}
+	}
+
+	of, err := newFile(state, name)
+	if err != nil {
+		return err
+	}
+
+	// openFile and recoverSwap work on the current buffer
+	prev := state.ollie
+	state.ollie = of
+	err = openFile(state)
+	if err == nil && !of.ReadOnly {
+		// A read-only buffer was opened because someone else is editing the
+		// file, so the swap file belongs to them
+		err = recoverSwap(state)
+		if err != nil {
+			of.Close()
+		}
+	}
+	state.ollie = prev
+	if err != nil {
+		return err
+	}
+
+	// Like ed the last line is the current one after reading a file, a big
+	// one isn't waited for until the line is needed
+	line, known := olliefile.KnownLen(of.Lines)
+	if !known {
+		line = lazyLastLine
+	}
+	state.buffers = append(state.buffers, &buffer{file: of, line: line, spellcheck: state.channels.ShouldSpellcheck})
+	switchBuffer(state, len(state.buffers)-1)
+	return nil
+}
+
+// Find a buffer by its number in the buffer list or by file name. A name
+// matches the name the file was opened with or just its base name.
+func findBuffer(state *State, param string) (int, bool) {
+	n, err := strconv.Atoi(param)
+	if err == nil {
+		return n - 1, n >= 1 && n <= len(state.buffers)
+	}
+	for i, b := range state.buffers {
+		if b.file.Name == param {
+			return i, true
+		}
+	}
+	for i, b := range state.buffers {
+		if filepath.Base(b.file.Name) == param {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Make buffer i the one being edited, keeping the state of the current one
+// in the buffer list
+func switchBuffer(state *State, i int) {
+	if state.current < len(state.buffers) && state.buffers[state.current].file == state.ollie {
+		cur := state.buffers[state.current]
+		cur.line = state.line
+		cur.spellcheck = state.channels.ShouldSpellcheck
+	}
+
+	b := state.buffers[i]
+	state.current = i
+	state.ollie = b.file
+	state.line = b.line
+	state.channels.ShouldSpellcheck = b.spellcheck
+}
+
+// Print the buffer list. The current buffer is marked with '*' and ones with
+// unsaved changes with '+'.
+func listBuffers(state *State) {
+	for i, b := range state.buffers {
+		cur, dirty := ' ', ' '
+		if i == state.current {
+			cur = '*'
+		}
+		if b.file.Modified() {
+			dirty = '+'
+		}
+		fmt.Printf("%c%d %c %s, %s\n", cur, i+1, dirty, b.file.Name, lineCount(b.file))
+	}
+}
+
+// lineCount describes how many lines a file has, without waiting for one
+// that is still being indexed
+func lineCount(of *olliefile.File) string {
+	n, known := olliefile.KnownLen(of.Lines)
+	if !known {
+		return fmt.Sprintf("%d lines so far", n)
+	}
+	return plural(n, "line")
+}
+
+// Handle the 'b' command, with no parameter it lists the buffers otherwise it
+// switches to the one named
+func bufferCommand(state *State, param string) error {
+	if param == "" {
+		listBuffers(state)
+		return nil
+	}
+	i, ok := findBuffer(state, param)
+	if !ok {
+		return fmt.Errorf("no buffer %q, 'b' lists them", param)
+	}
+	switchBuffer(state, i)
+	fmt.Printf("%s, %s\n", state.ollie.Name, lineCount(state.ollie))
+	return nil
+}
+
+// Close a buffer, the current one when param is empty. Unsaved changes are
+// only thrown away when forced. Closing the last buffer leaves an empty one.
+func closeBuffer(state *State, param string, force bool) error {
+	i := state.current
+	if param != "" {
+		var ok bool
+		i, ok = findBuffer(state, param)
+		if !ok {
+			return fmt.Errorf("no buffer %q, 'b' lists them", param)
+		}
+	}
+
+	b := state.buffers[i]
+	if b.file.Modified() && !force {
+		return fmt.Errorf("%s has unsaved changes, use 'bd!' to close it anyway", b.file.Name)
+	}
+	b.file.Close()
+	fmt.Println("closed", b.file.Name)
+
+	state.buffers = append(state.buffers[:i], state.buffers[i+1:]...)
+	if len(state.buffers) == 0 {
+		of, err := newFile(state, scratchName)
+		if err != nil {
+			return err
+		}
+		state.buffers = append(state.buffers, &buffer{file: of, spellcheck: state.channels.ShouldSpellcheck})
+	}
+
+	switch {
+	case i < state.current:
+		state.current -= 1
+	case i == state.current:
+		// The closed buffer's state is gone, don't save it into its neighbour
+		state.current = len(state.buffers)
+		switchBuffer(state, min(i, len(state.buffers)-1))
+	}
+	return nil
+}
+
+// Whether any buffer has unsaved changes
+func anyModified(state *State) bool {
+	for _, b := range state.buffers {
+		if b.file.Modified() {
+			return true
+		}
+	}
+	return false
+}
+
+// Close every buffer when the editor exits
+func closeBuffers(state *State) {
+	for _, b := range state.buffers {
+		b.file.Close()
+	}
+}
+
+// Release every buffer when the editor's input ends, keeping the swap files
+// of the ones with unsaved changes
+func releaseBuffers(state *State) {
+	for _, b := range state.buffers {
+		b.file.Release()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommands runs each command as its own undo step, like typing them does
+func runCommands(t *testing.T, state *State, commands ...string) {
+	t.Helper()
+	for _, c := range commands {
+		state.ollie.BeginChange()
+		if err := runCommand(state, c); err != nil {
+			t.Fatalf("%q = %v", c, err)
+		}
+	}
+}
+
+// checkBuffer checks which buffer is being edited and the state it has
+func checkBuffer(t *testing.T, state *State, name string, lines, line int, spellcheck bool) {
+	t.Helper()
+	if got := filepath.Base(state.ollie.Name); got != name {
+		t.Fatalf("editing %s, want %s", got, name)
+	}
+	if cur := state.buffers[state.current].file; cur != state.ollie {
+		t.Errorf("current buffer is %s but editing %s", cur.Name, state.ollie.Name)
+	}
+	if n := state.ollie.Lines.Len(); n != lines {
+		t.Errorf("%s has %d lines, want %d", name, n, lines)
+	}
+	if state.line != line {
+		t.Errorf("%s current line = %d, want %d", name, state.line, line)
+	}
+	if state.channels.ShouldSpellcheck != spellcheck {
+		t.Errorf("%s spellcheck = %v, want %v", name, state.channels.ShouldSpellcheck, spellcheck)
+	}
+}
+
+func TestBuffers(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"one.txt", "two.txt", "three.txt"} {
+		text := fmt.Sprintf("%s 1\n%s 2\n%s 3\n", name, name, name)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := &State{wordInput: bufio.NewScanner(strings.NewReader("")), entry: textEntry{at: -1}}
+	t.Cleanup(func() { closeBuffers(state) })
+	for _, name := range []string{"one.txt", "two.txt"} {
+		if err := openBuffer(state, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Like ed the last line is current after opening a file
+	checkBuffer(t, state, "two.txt", 3, 3, false)
+	runCommands(t, state, "2d", "p on")
+	checkBuffer(t, state, "two.txt", 2, 2, true)
+
+	// Each buffer keeps its own current line, spellcheck and undo history
+	runCommands(t, state, "b 1")
+	checkBuffer(t, state, "one.txt", 3, 3, false)
+	runCommands(t, state, "1d", "u")
+	checkBuffer(t, state, "one.txt", 3, 1, false)
+	if _, err := state.ollie.Undo(); err == nil {
+		t.Error("undo in one.txt undid the change made to two.txt")
+	}
+	runCommands(t, state, "b two.txt")
+	checkBuffer(t, state, "two.txt", 2, 2, true)
+	runCommands(t, state, "u")
+	checkBuffer(t, state, "two.txt", 3, 2, true)
+
+	if err := openBuffer(state, filepath.Join(dir, "three.txt")); err != nil {
+		t.Fatal(err)
+	}
+	runCommands(t, state, "p off", "b 2", "1p")
+
+	// Closing a buffer before the current one moves it down the list
+	runCommands(t, state, "bd 1")
+	if len(state.buffers) != 2 || state.current != 0 {
+		t.Fatalf("after closing buffer 1, %d buffers and buffer %d is current", len(state.buffers), state.current+1)
+	}
+	checkBuffer(t, state, "two.txt", 3, 1, true)
+
+	// Closing the current one switches to its neighbour without handing it
+	// the closed buffer's state
+	runCommands(t, state, "bd")
+	if len(state.buffers) != 1 || state.current != 0 {
+		t.Fatalf("after closing the current buffer, %d buffers and buffer %d is current", len(state.buffers), state.current+1)
+	}
+	checkBuffer(t, state, "three.txt", 3, 3, false)
+}
@@ -0,0 +1,62 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux || darwin || freebsd || netbsd || dragonfly
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Turn off echoing of typed characters so passphrases aren't shown, the
+// returned function turns it back on. Nothing is shown when stdin isn't a
+// terminal so then there's nothing to turn off.
+func echoOff() (func(), error) {
+	fd := os.Stdin.Fd()
+	var t syscall.Termios
+	if err := termios(fd, ioctlGetTermios, &t); err != nil {
+		if errors.Is(err, syscall.ENOTTY) {
+			return func() {}, nil
+		}
+		return nil, fmt.Errorf("can't turn off echo: %w", err)
+	}
+	old := t
+	t.Lflag &^= syscall.ECHO
+	if err := termios(fd, ioctlSetTermios, &t); err != nil {
+		return nil, fmt.Errorf("can't turn off echo: %w", err)
+	}
+	return func() { termios(fd, ioctlSetTermios, &old) }, nil
+}
+
+// Get or set the terminal settings of fd with the ioctl req
+func termios(fd, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
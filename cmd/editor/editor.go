@@ -27,9 +27,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"git.sr.ht/~travgm/ollie/conf"
 	"git.sr.ht/~travgm/ollie/olliefile"
+	"git.sr.ht/~travgm/ollie/search"
 	"git.sr.ht/~travgm/ollie/spellcheck"
 	"git.sr.ht/~travgm/ollie/version"
 )
@@ -39,100 +42,298 @@ import (
 // for spellchecking and a done channel to signify the program is exiting
 //
 // command should only be one of the valid editor const commands
+//
+// ollie is the buffer being edited and line its current line, the other open
+// buffers are kept in buffers, see buffers.go
 type State struct {
-	channels  spellcheck.Channels
-	command   string
-	wordInput *bufio.Scanner
-	ollie     *olliefile.File
-	conf      *conf.Settings
+	channels   spellcheck.Channels
+	command    string
+	wordInput  *bufio.Scanner
+	ollie      *olliefile.File
+	line       int
+	buffers    []*buffer
+	current    int
+	conf       *conf.Settings
+	maxLine    int
+	readOnly   bool   // open files read-only, the -R flag
+	passphrase string // encrypt files with this, the -x flag
+
+	// q was refused because of unsaved changes, the next q quits anyway
+	quitWarned bool
+
+	// The regular expression used last, an empty one like // means this
+	lastPattern *search.Matcher
+	// The replacement of the last substitution, s/re/%/ uses it again
+	lastReplacement string
+	// A global command is running its commands
+	inGlobal bool
+	// Where the lines typed in append mode go
+	entry textEntry
+}
+
+// textEntry is where getWords puts the lines typed, at is the index in the
+// buffer of the next one or -1 to add them to the end. withCommand is set
+// when they are part of the same undo step as the command that asked for
+// them, like 'c' which deletes the lines they replace.
+type textEntry struct {
+	at          int
+	withCommand bool
 }
 
 // Editor commands
 const (
-	WRITE_FILE    = "w"
-	APPEND        = "a"
-	FILE_INFO     = "i"
-	SPELLCHECK    = "p"
-	FIX_LINE      = "f"
-	EXEC_CMD      = "e"
-	QUIT_EDITOR   = "q"
-	DEL_LAST_LINE = "d"
-	SEARCH_TEXT   = "s"
-	COMMAND_MODE  = "."
+	WRITE_FILE   = "w"
+	WRITE_FORCE  = "w!"
+	WRITE_APPEND = "W"
+	SAVE_AS      = "saveas"
+	READ_FILE    = "r"
+	RELOAD_FILE  = "E"
+	RELOAD_FORCE = "E!"
+	APPEND       = "a"
+	INSERT       = "I"
+	CHANGE       = "c"
+	FILE_INFO    = "i"
+	SPELLCHECK   = "p"
+	NUMBER_LINES = "n"
+	LIST_LINES   = "l"
+	LINE_NUMBER  = "="
+	FIX_LINE     = "f"
+	EXEC_CMD     = "e"
+	QUIT_EDITOR  = "q"
+	QUIT_FORCE   = "Q"
+	DELETE_LINES = "d"
+	SEARCH_TEXT  = "s"
+	SUBSTITUTE   = "s/" // s followed right away by any delimiter
+	GLOBAL       = "g"
+	INVERSE      = "v"
+	GLOBAL_ASK   = "G"
+	INVERSE_ASK  = "V"
+	UNDO         = "u"
+	REDO         = "U"
+	LINE_ENDING  = "eol"
+	ENCODING     = "enc"
+	COMPRESSION  = "compress"
+	HEX_DUMP     = "hex"
+	PATCH_BYTES  = "patch"
+	BACKUPS      = "backup"
+	BACKUP_FORCE = "backup!"
+	READ_ONLY    = "ro"
+	ENCRYPT      = "x"
+	OPEN_FILE    = "o"
+	BUFFERS      = "b"
+	CLOSE_BUFFER = "bd"
+	CLOSE_FORCE  = "bd!"
+	COMMAND_MODE = "."
 )
 
+// How the commands that take line addresses default them, the others can't
+// be given any
+var commandAddressing = map[string]addressing{
+	APPEND:       {def: lastLine, zero: true},
+	INSERT:       {def: currentLine, zero: true},
+	CHANGE:       {def: currentLine},
+	SPELLCHECK:   {def: currentLine},
+	NUMBER_LINES: {def: currentLine},
+	LIST_LINES:   {def: currentLine},
+	DELETE_LINES: {def: currentLine},
+	FIX_LINE:     {def: currentLine},
+	SEARCH_TEXT:  {def: wholeBuffer},
+	SUBSTITUTE:   {def: currentLine},
+	GLOBAL:       {def: wholeBuffer},
+	INVERSE:      {def: wholeBuffer},
+	GLOBAL_ASK:   {def: wholeBuffer},
+	INVERSE_ASK:  {def: wholeBuffer},
+	WRITE_FILE:   {def: wholeBuffer},
+	WRITE_FORCE:  {def: wholeBuffer},
+	WRITE_APPEND: {def: wholeBuffer},
+	READ_FILE:    {def: lastLine, zero: true},
+	LINE_NUMBER:  {def: lastLine, zero: true},
+}
+
 // Checks state.command and runs the proper routines for it
 func execIoCommand(state *State) {
+	// Each command is a single step for undo
+	state.ollie.BeginChange()
+	err := runCommand(state, state.command)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
 
-	cmd, param, err := parseCommandArgs(state)
+// runCommand runs a single command line, with its addresses, as part of the
+// current undo step
+func runCommand(state *State, command string) error {
+	lines, cmd, param, err := parseCommandArgs(state, command)
 	if err != nil {
-		fmt.Errorf("Invalid command/parameters\n")
-		return
+		return err
 	}
 
+	// 'p on' and 'p off' turn spellchecking on and off, otherwise 'p' prints
+	// lines like it does in ed
+	spellcheck := cmd == SPELLCHECK && (param == "on" || param == "off")
+	if a, ok := commandAddressing[cmd]; ok && !spellcheck {
+		lines, err = a.resolve(lines, state)
+		if err != nil {
+			return err
+		}
+	} else if lines.given && cmd != "" {
+		return fmt.Errorf("'%s' doesn't take a line range", cmd)
+	}
+	if state.inGlobal && notInGlobal[cmd] {
+		return fmt.Errorf("'%s' can't be used inside a global command", cmd)
+	}
+
+	defer func() {
+		if !olliefile.HasLines(state.ollie.Lines, state.line) {
+			state.line = state.ollie.Lines.Len()
+		}
+	}()
+
 	switch cmd {
-	case APPEND:
-		break
+	case "":
+		// Just an address moves to that line and prints it, without one
+		// it moves to the next line
+		if param != "" {
+			return fmt.Errorf("unknown command")
+		}
+		if !lines.given {
+			lines.last = dot(state) + 1
+		}
+		if lines.last < 1 || !olliefile.HasLines(state.ollie.Lines, lines.last) {
+			return fmt.Errorf("invalid address")
+		}
+		lines.first = lines.last
+		printLines(state, lines, SPELLCHECK)
+	case APPEND, INSERT, CHANGE:
+		return startEntry(state, cmd, lines)
 	case FILE_INFO:
 		fmt.Println(state.ollie)
-	case SPELLCHECK:
-		switch param {
-		case "on":
-			state.channels.ShouldSpellcheck = true
-		case "off":
-			state.channels.ShouldSpellcheck = false
+	case SPELLCHECK, NUMBER_LINES, LIST_LINES:
+		switch {
+		case spellcheck:
+			state.channels.ShouldSpellcheck = param == "on"
+		case param != "" && cmd == SPELLCHECK:
+			return fmt.Errorf("valid parameter for spellcheck is 'on' or 'off'")
+		case param != "":
+			return fmt.Errorf("'%s' takes no parameters", cmd)
 		default:
-			fmt.Println("valid parameter for spellcheck is 'on' or 'off'")
+			printLines(state, lines, cmd)
 		}
-	case DEL_LAST_LINE:
-		line, err := deleteLastLine(state)
+	case LINE_NUMBER:
+		fmt.Println(lines.last)
+	case DELETE_LINES:
+		err := deleteLines(state, lines)
 		if err != nil {
-			fmt.Println("error deleting last line", err)
-		} else {
-			fmt.Println("cleared line", line)
+			return fmt.Errorf("error deleting lines %w", err)
 		}
 	case FIX_LINE:
-		state.wordInput.Scan()
-		err := state.ollie.UpdateLine(param, state.wordInput.Text())
-		if err != nil {
-			fmt.Println(err)
-		} else {
-			fmt.Println("updated line", param)
-		}
+		return fixLine(state, lines, param)
 	case SEARCH_TEXT:
-		_, err := searchLinesBuffer(state, param)
+		_, err := searchLinesBuffer(state, lines, param)
 		if err != nil {
-			fmt.Println("'s' needs a text string to search the buffer for")
+			return fmt.Errorf("'s' needs a text string to search the buffer for")
 		}
+	case SUBSTITUTE:
+		return substitute(state, lines, param)
+	case GLOBAL, INVERSE, GLOBAL_ASK, INVERSE_ASK:
+		return global(state, lines, cmd, param)
 	case EXEC_CMD:
 		res, err := shellCommand(param)
 		if err != nil {
-			fmt.Println("'e' error", err)
-		} else {
-			fmt.Println(string(res))
+			return fmt.Errorf("'e' error %w", err)
 		}
-	case WRITE_FILE:
-		err := writeToDisk(state, param)
+		fmt.Println(string(res))
+	case UNDO:
+		line, err := state.ollie.Undo()
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
+		state.line = min(line+1, state.ollie.Lines.Len())
+		fmt.Println("undid changes at line", line+1)
+	case REDO:
+		line, err := state.ollie.Redo()
+		if err != nil {
+			return err
+		}
+		state.line = min(line+1, state.ollie.Lines.Len())
+		fmt.Println("redid changes at line", line+1)
+	case LINE_ENDING:
+		if param == "" {
+			fmt.Println("line endings are", state.ollie.EOL)
+			break
+		}
+		eol, err := olliefile.ParseLineEnding(param)
+		if err == nil {
+			err = state.ollie.SetLineEnding(eol)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("line endings set to", eol)
+	case ENCODING:
+		if param == "" {
+			fmt.Println("encoding is", state.ollie.Encoding)
+			break
+		}
+		enc, bom, err := olliefile.ParseEncoding(param)
+		if err == nil {
+			err = state.ollie.SetEncoding(enc, bom)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("file will be saved as", param)
+	case COMPRESSION:
+		if param == "" {
+			fmt.Println("compression is", state.ollie.Compression)
+			break
+		}
+		c, err := olliefile.ParseCompression(param)
+		if err == nil {
+			err = state.ollie.SetCompression(c)
+		}
+		if err != nil {
+			return err
 		}
+		fmt.Println("file will be saved with compression", c)
+	case HEX_DUMP:
+		return hexDump(state, param)
+	case PATCH_BYTES:
+		return patchBytes(state, param)
+	case WRITE_FILE, WRITE_FORCE:
+		return writeToDisk(state, lines, param, cmd == WRITE_FORCE)
+	case WRITE_APPEND:
+		return appendToDisk(state, lines, param)
+	case READ_FILE:
+		return readIntoBuffer(state, lines, param)
+	case SAVE_AS:
+		return saveAs(state, param)
+	case RELOAD_FILE, RELOAD_FORCE:
+		return reloadFile(state, cmd == RELOAD_FORCE)
+	case BACKUPS, BACKUP_FORCE:
+		return backupCommand(state, param, cmd == BACKUP_FORCE)
+	case ENCRYPT:
+		return setPassphrase(state)
+	case READ_ONLY:
+		return readOnlyCommand(state, param)
+	case OPEN_FILE:
+		if param == "" {
+			return fmt.Errorf("'o' needs the name of the file to open")
+		}
+		return openBuffer(state, param)
+	case BUFFERS:
+		return bufferCommand(state, param)
+	case CLOSE_BUFFER, CLOSE_FORCE:
+		return closeBuffer(state, param, cmd == CLOSE_FORCE)
 	default:
-		fmt.Println("unknown command")
+		return fmt.Errorf("unknown command")
 	}
 
-	return
+	return nil
 }
 
-func initEditor(filename string, spell bool) (State, error) {
-	of := &olliefile.File{Name: "junk.ollie"}
-	if filename != "" {
-		of.Name = filename
-		of.CreateFile()
-	}
-	config, err := conf.FromFile(conf.DefaultConfFile)
-	if !errors.Is(err, os.ErrNotExist) {
-		return State{}, nil
-	}
+func initEditor(filenames []string, spell bool, maxLine int, readOnly bool, encrypt bool) (State, error) {
+	config := loadConfig()
 
 	spChannels := spellcheck.Channels{
 		ShouldSpellcheck: spell,
@@ -145,15 +346,99 @@ func initEditor(filename string, spell bool) (State, error) {
 	state := State{
 		channels:  spChannels,
 		wordInput: bufio.NewScanner(os.Stdin),
-		ollie:     of,
 		conf:      config,
+		maxLine:   maxLine,
+		readOnly:  readOnly,
+		entry:     textEntry{at: -1},
+	}
+
+	// Like ed -x the passphrase is asked for once and used for every file
+	if encrypt {
+		pass, ok := readPassphrase(&state, "passphrase: ")
+		if !ok || pass == "" {
+			return State{}, fmt.Errorf("-x needs a passphrase")
+		}
+		state.passphrase = pass
+	}
+
+	for _, name := range filenames {
+		err := openBuffer(&state, name)
+		if err != nil {
+			closeBuffers(&state)
+			return State{}, err
+		}
+	}
+	if len(filenames) > 1 {
+		switchBuffer(&state, 0)
+	}
+
+	if len(state.buffers) == 0 {
+		of, err := newFile(&state, scratchName)
+		if err != nil {
+			return State{}, err
+		}
+		state.buffers = append(state.buffers, &buffer{file: of, spellcheck: spell})
+		switchBuffer(&state, 0)
 	}
 
 	return state, nil
 }
 
+// loadConfig reads the config file from the home directory, not having one
+// is fine and leaves everything at the defaults. So does one that can't be
+// read, after a warning, rather than keeping ollie from starting
+func loadConfig() *conf.Settings {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	config, err := conf.FromFile(home + conf.DefaultConfFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: config %s: %v, using the defaults\n", home+conf.DefaultConfFile, err)
+		return nil
+	}
+	return config
+}
+
+// Sets up the backups olliefile makes when saving from the backup,
+// backup-dir and backup-keep config keys
+func applyBackupConfig(of *olliefile.File, config *conf.Settings) error {
+	if mode, ok := config.Get("backup"); ok {
+		backup, err := olliefile.ParseBackupMode(mode)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		of.Backup = backup
+	}
+	if dir, ok := config.Get("backup-dir"); ok {
+		if rest, found := strings.CutPrefix(dir, "~/"); found {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("config: backup-dir: %w", err)
+			}
+			dir = filepath.Join(home, rest)
+		}
+		of.BackupDir = dir
+	}
+	if keep, ok := config.GetInt("backup-keep"); ok {
+		of.BackupKeep = keep
+	}
+	return nil
+}
+
+// The command prompt, it starts with '+' when the buffer has unsaved changes
+func prompt(state *State) string {
+	if state.ollie.Modified() {
+		return "+@ "
+	}
+	return "@ "
+}
+
 func printUsage() {
-	fmt.Println("Usage: ollie <file>")
+	fmt.Println("Usage: ollie [file...]")
 	fmt.Println("Flags:")
 	flag.PrintDefaults()
 }
@@ -175,42 +460,69 @@ func main() {
 func run() error {
 	aboutFlag := flag.Bool("version", false, "Display version information")
 	spellFlag := flag.Bool("spcheck", false, "Turn spellchecking on, default is off")
+	readOnlyFlag := flag.Bool("R", false, "Open files read-only")
+	encryptFlag := flag.Bool("x", false, "Ask for a passphrase to open and save encrypted files")
+	maxLineFlag := flag.Int("maxline", 0, "Refuse to load files with lines longer than this many bytes, default is no limit")
 
+	flag.Usage = printUsage
 	flag.Parse()
 	if *aboutFlag {
 		version.DisplayAbout()
 		return nil
 	}
 
-	if flag.NArg() > 1 {
-		printUsage()
-		return fmt.Errorf("incorrect number of arguments")
-
-	}
-
-	state, err := initEditor(flag.Arg(0), *spellFlag)
+	state, err := initEditor(flag.Args(), *spellFlag, *maxLineFlag, *readOnlyFlag, *encryptFlag)
 	if err != nil {
 		return err
 	}
 
 	go spellcheck.ExecSpellchecker(state.channels)
 
+	return editLoop(&state)
+}
+
+// editLoop reads lines of text and the commands between them until the
+// editor quits
+func editLoop(state *State) error {
 	for {
-		err := getWords(&state)
+		err := getWords(state)
 		if err != nil {
 			fmt.Println(err)
 			close(state.channels.Done)
+			closeBuffers(state)
 			return err
 		}
-		fmt.Print("@ ")
-		state.wordInput.Scan()
-		state.command = state.wordInput.Text()
-		if state.command == QUIT_EDITOR {
+
+		for {
+			fmt.Print(prompt(state))
+			if !state.wordInput.Scan() {
+				// Nothing left to read, so there is nobody to warn either.
+				// The terminal may have died, keep the unsaved changes in
+				// the swap files.
+				close(state.channels.Done)
+				releaseBuffers(state)
+				return nil
+			}
+			state.command = state.wordInput.Text()
+
+			// Like ed, quitting with unsaved changes only prints '?' the
+			// first time. The next command is read straight away so a
+			// second q isn't taken as text.
+			if state.command != QUIT_EDITOR || !anyModified(state) || state.quitWarned {
+				break
+			}
+			fmt.Println("?")
+			state.quitWarned = true
+		}
+		if state.command == QUIT_EDITOR || state.command == QUIT_FORCE {
 			close(state.channels.Done)
-			break
+			closeBuffers(state)
+			return nil
 		}
-		execIoCommand(&state)
+		state.quitWarned = false
+		// Back in append mode lines are added to the end unless the command
+		// says otherwise
+		state.entry = textEntry{at: -1}
+		execIoCommand(state)
 	}
-
-	return nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~travgm/ollie/conf"
+	"git.sr.ht/~travgm/ollie/olliefile"
+)
+
+// loopState makes a state for editLoop with the buffer of file open and
+// input to read
+func loopState(file *olliefile.File, input ...string) *State {
+	state := &State{
+		ollie:     file,
+		line:      file.Lines.Len(),
+		buffers:   []*buffer{{file: file, line: file.Lines.Len()}},
+		wordInput: bufio.NewScanner(strings.NewReader(strings.Join(input, "\n"))),
+		entry:     textEntry{at: -1},
+	}
+	state.channels.Done = make(chan string, 1)
+	return state
+}
+
+func TestQuitWarning(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  *olliefile.File
+		input []string
+		lines int    // lines in the buffer after quitting
+		left  string // the input not read yet
+	}{
+		{"second q quits", testState().ollie, []string{".", "q", "q", "after"}, 10, "after"},
+		{"q after another command warns again", testState().ollie, []string{".", "q", "=", ".", "q", "q", "after"}, 10, "after"},
+		{"unmodified quits at once", olliefile.NewFile("empty.txt"), []string{".", "q", "after"}, 0, "after"},
+	}
+	for _, tt := range tests {
+		state := loopState(tt.file, tt.input...)
+		if err := editLoop(state); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if n := tt.file.Lines.Len(); n != tt.lines {
+			t.Errorf("%s: quitting left %d lines, want %d", tt.name, n, tt.lines)
+		}
+		if !state.wordInput.Scan() || state.wordInput.Text() != tt.left {
+			t.Errorf("%s: quit before reading up to %q", tt.name, tt.left)
+		}
+	}
+}
+
+func TestEndOfInputKeepsSwap(t *testing.T) {
+	tests := []struct {
+		input []string
+		kept  bool
+	}{
+		{[]string{"typed"}, true},            // the terminal died in append mode
+		{[]string{"typed", ".", "Q"}, false}, // quitting throws the changes away
+		{[]string{"typed", ".", "w"}, false}, // and so does saving them
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), "a.txt")
+		if err := os.WriteFile(name, []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		file := olliefile.NewFile(name)
+		file.Swap = true
+		if err := file.CreateFile(); err != nil {
+			t.Fatal(err)
+		}
+		if err := editLoop(loopState(file, tt.input...)); err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		if _, err := os.Stat(olliefile.SwapName(name)); (err == nil) != tt.kept {
+			t.Errorf("%q: swap file kept = %v, want %v", tt.input, err == nil, tt.kept)
+		}
+	}
+}
+
+func TestBadConfigUsesDefaults(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.WriteFile(home+conf.DefaultConfFile, []byte("backup simple\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if config := loadConfig(); config != nil {
+		t.Errorf("a config that can't be parsed gave %v", config)
+	}
+
+	if err := os.WriteFile(home+conf.DefaultConfFile, []byte("backup = simple\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if mode, _ := loadConfig().Get("backup"); mode != "simple" {
+		t.Errorf("backup = %q, want simple", mode)
+	}
+}
@@ -0,0 +1,160 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Commands that can't be run by a global command, they would start another
+// one, wait for text to be typed, undo changes outside it or change which
+// buffer the marked lines are in
+var notInGlobal = map[string]bool{
+	GLOBAL:       true,
+	INVERSE:      true,
+	GLOBAL_ASK:   true,
+	INVERSE_ASK:  true,
+	APPEND:       true,
+	INSERT:       true,
+	CHANGE:       true,
+	FIX_LINE:     true,
+	UNDO:         true,
+	REDO:         true,
+	RELOAD_FILE:  true,
+	RELOAD_FORCE: true,
+	OPEN_FILE:    true,
+	BUFFERS:      true,
+	CLOSE_BUFFER: true,
+	CLOSE_FORCE:  true,
+}
+
+// global runs g/re/commands and its relatives on the lines in r. g marks
+// every line matching re and v every line that doesn't, then the commands
+// are run on each marked line in turn with it as the current line. Lines
+// the commands delete are unmarked and the others keep their marks as lines
+// move. Everything the commands change is a single undo step. A
+// substitution that finds nothing on a marked line isn't an error, the
+// global command carries on with the next command and line.
+//
+// The command list is one command per line, a line ending in '\' continues
+// on the next one, and prints the lines when it is empty. G and V instead
+// print each marked line and ask for the commands to run on it, an empty
+// line skips it and '&' repeats the last commands.
+func global(state *State, r lineRange, cmd, param string) error {
+	if param == "" {
+		return fmt.Errorf("'%s' needs /re/", cmd)
+	}
+	if err := checkDelimiter(param[0]); err != nil {
+		return err
+	}
+	pattern, list, closed := splitDelimited(param[1:], param[0])
+	if !closed {
+		return fmt.Errorf("missing delimiter after %s", param)
+	}
+	m, err := compilePattern(state, pattern)
+	if err != nil {
+		return err
+	}
+
+	invert := cmd == INVERSE || cmd == INVERSE_ASK
+	var marked []int
+	state.ollie.Lines.Range(r.first-1, r.last, func(i int, line string) bool {
+		if m.MatchString(line) != invert {
+			marked = append(marked, i)
+		}
+		return true
+	})
+	if len(marked) == 0 {
+		return fmt.Errorf("no match for %s", m)
+	}
+
+	interactive := cmd == GLOBAL_ASK || cmd == INVERSE_ASK
+	var commands []string
+	if !interactive {
+		commands = readCommandList(state, list)
+	} else if list != "" {
+		return fmt.Errorf("'%s' asks for the commands to run, it takes none", cmd)
+	}
+
+	state.ollie.SetMarks(marked)
+	state.inGlobal = true
+	defer func() {
+		state.ollie.ClearMarks()
+		state.inGlobal = false
+	}()
+
+	var last []string
+	defer fmt.Printf("%s matched\n", plural(len(marked), "line"))
+	for {
+		line, ok := state.ollie.NextMark()
+		if !ok {
+			break
+		}
+		state.line = line + 1
+
+		if interactive {
+			fmt.Println(state.ollie.Lines.Line(line))
+			if !state.wordInput.Scan() {
+				break
+			}
+			switch input := state.wordInput.Text(); input {
+			case "":
+				continue
+			case "&":
+				if last == nil {
+					return fmt.Errorf("no previous command to repeat")
+				}
+				commands = last
+			default:
+				commands = readCommandList(state, input)
+			}
+			last = commands
+		}
+
+		for _, c := range commands {
+			err := runCommand(state, c)
+			if errors.Is(err, errNoSubstitution) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readCommandList splits the commands of a global command into lines,
+// reading more from the input while the last line ends in '\'. An empty
+// list prints the line.
+func readCommandList(state *State, list string) []string {
+	for strings.HasSuffix(list, "\\") && state.wordInput.Scan() {
+		list = list[:len(list)-1] + "\n" + state.wordInput.Text()
+	}
+	list = strings.TrimSuffix(list, "\\")
+	if list == "" {
+		return []string{SPELLCHECK}
+	}
+	return strings.Split(list, "\n")
+}
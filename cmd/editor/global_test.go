@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGlobal(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		// Deleting the line after each odd one also deletes the even marks
+		{"g/[13579]$/+1d", []string{"line 1", "line 3", "line 5", "line 7", "line 9"}},
+		{"v/[13579]$/d", []string{"line 1", "line 3", "line 5", "line 7", "line 9"}},
+		{"2,4g/line/s/line/L/\\\ns/L/LL/", []string{"line 1", "LL 2", "LL 3", "LL 4", "line 5",
+			"line 6", "line 7", "line 8", "line 9", "line 10"}},
+		// Line 1 has no "0" to replace, lines after it still get theirs
+		{"g/1/s/0/X/", []string{"line 1", "line 2", "line 3", "line 4", "line 5",
+			"line 6", "line 7", "line 8", "line 9", "line 1X"}},
+		{"g/1/m", nil},
+		{"g/1/u", nil},
+		{"g/1/U", nil},
+		{"g/1/f", nil},
+		{"g/1/g/2/d", nil},
+		{"g/nope/d", nil},
+	}
+	for _, tt := range tests {
+		state := testState()
+		// The second line of a command list is read like the rest of the
+		// input
+		first, rest, _ := strings.Cut(tt.cmd, "\n")
+		state.wordInput = bufio.NewScanner(strings.NewReader(rest))
+		state.ollie.BeginChange()
+		err := runCommand(state, first)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q succeeded", tt.cmd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q = %v", tt.cmd, err)
+			continue
+		}
+		got := state.ollie.Lines.Slice(0, state.ollie.Lines.Len())
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q left %q, want %q", tt.cmd, got, tt.want)
+		}
+
+		// The whole global command is one undo step
+		state.ollie.Undo()
+		if n := state.ollie.Lines.Len(); n != 10 || state.ollie.Lines.Line(1) != "line 2" {
+			t.Errorf("%q undone left %d lines", tt.cmd, n)
+		}
+	}
+}
+
+func TestGlobalInteractive(t *testing.T) {
+	state := testState()
+	// Delete line 1 and skip line 10
+	state.wordInput = bufio.NewScanner(strings.NewReader("d\n\n"))
+	if err := runCommand(state, "G/1/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := state.ollie.Lines.Len(); got != 9 || state.ollie.Lines.Line(8) != "line 10" {
+		t.Errorf("G left %d lines ending with %q", got, state.ollie.Lines.Line(got-1))
+	}
+
+	state = testState()
+	state.wordInput = bufio.NewScanner(strings.NewReader("s/line/x/\n&\n"))
+	if err := runCommand(state, "8,$V/9/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := state.ollie.Lines.Slice(7, 10); fmt.Sprint(got) != "[x 8 line 9 x 10]" {
+		t.Errorf("V changed lines 8 to 10 to %q", got)
+	}
+}
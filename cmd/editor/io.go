@@ -22,26 +22,34 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"unicode"
 
+	"git.sr.ht/~travgm/ollie/olliefile"
 	"git.sr.ht/~travgm/ollie/search"
 )
 
-func parseCommandArgs(state *State) (string, string, error) {
-	c := strings.Split(state.command, " ")
-
-	cmdLen := len(c)
-	cmd := c[0]
-
-	param := ""
-	if cmdLen > 1 {
-		param = strings.Join(c[1:], " ")
+// parseCommandArgs splits a command line into its line addresses, the
+// command and the command's parameter
+func parseCommandArgs(state *State, command string) (lineRange, string, string, error) {
+	lines, rest, err := parseAddresses(state, command)
+	if err != nil {
+		return lines, "", "", err
 	}
-
-	return cmd, param, nil
+	// 's text' searches, s/re/replacement/ with the delimiter right after
+	// the s substitutes
+	if len(rest) > 1 && rest[0] == 's' && rest[1] != ' ' && !unicode.IsLetter(rune(rest[1])) {
+		return lines, SUBSTITUTE, rest[1:], nil
+	}
+	cmd, param := splitCommand(rest)
+	return lines, cmd, param, nil
 }
 
 func shellCommand(command string) ([]byte, error) {
@@ -58,17 +66,70 @@ func shellCommand(command string) ([]byte, error) {
 
 }
 
-func deleteLastLine(state *State) (int, error) {
-	line := strconv.Itoa(len(state.ollie.Lines))
-	if state.ollie.FileHandle != nil {
-		err := state.ollie.UpdateLine(line, "")
+// shellOutput runs command with the shell and returns what it wrote to
+// stdout. stderr goes to the terminal rather than into the buffer, and a
+// command that fails is an error.
+func shellOutput(command string) ([]byte, error) {
+	if command == "" {
+		return nil, fmt.Errorf("no command specified to run")
+	}
+	scmd := exec.Command("sh", "-c", command)
+	scmd.Stderr = os.Stderr
+	res, err := scmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("!%s: %w", command, err)
+	}
+	return res, nil
+}
+
+// deleteLines removes the lines in r, the line after them becomes the
+// current line or the new last line if there is none
+func deleteLines(state *State, r lineRange) error {
+	err := state.ollie.ReplaceLines(r.first-1, r.last)
+	if err != nil {
+		return err
+	}
+	state.line = min(r.first, state.ollie.Lines.Len())
+	if state.inGlobal {
+		return nil
+	}
+	if r.first == r.last {
+		fmt.Println("cleared line", r.first)
+	} else {
+		fmt.Printf("cleared lines %d-%d\n", r.first, r.last)
+	}
+	return nil
+}
+
+// fixLine replaces the addressed line with the next line typed. The line can
+// also be given as the parameter, 'f 3' is the same as '3f'.
+func fixLine(state *State, r lineRange, param string) error {
+	if state.ollie.ReadOnly {
+		return olliefile.ErrReadOnly
+	}
+	if param != "" && !r.given {
+		var rest string
+		var err error
+		r, rest, err = parseAddresses(state, param)
+		if err == nil && (!r.given || rest != "") {
+			err = fmt.Errorf("invalid address %s", param)
+		}
 		if err != nil {
-			return -1, err
+			return err
 		}
 	}
-	state.ollie.Lines = state.ollie.Lines[:len(state.ollie.Lines)-1]
-	state.ollie.LineCount -= 1
-	return state.ollie.LineCount + 1, nil
+	if r.last < 1 {
+		return fmt.Errorf("invalid address")
+	}
+
+	state.wordInput.Scan()
+	err := state.ollie.ReplaceLines(r.last-1, r.last, state.wordInput.Text())
+	if err != nil {
+		return err
+	}
+	state.line = r.last
+	fmt.Println("updated line", r.last)
+	return nil
 }
 
 // Save words into state line buffer
@@ -77,6 +138,12 @@ func getWords(state *State) error {
 		return fmt.Errorf("GetWords Error. State is null\n")
 	}
 
+	// Everything typed until the next command is undone together, and with
+	// the command itself when it replaced lines
+	if !state.entry.withCommand {
+		state.ollie.BeginChange()
+	}
+
 	for state.wordInput.Scan() {
 		if state.wordInput.Text() == COMMAND_MODE {
 			break
@@ -90,50 +157,399 @@ func getWords(state *State) error {
 			}
 		}
 
-		state.ollie.Lines = append(state.ollie.Lines, state.wordInput.Text())
-		state.ollie.LineCount += 1
-		state.ollie.WordCount += len(strings.Split(" ", state.wordInput.Text()))
-		fmt.Printf("%d:%d\n", state.ollie.LineCount, len(state.wordInput.Text()))
+		at := state.entry.at
+		if at < 0 {
+			at = state.ollie.Lines.Len()
+		}
+		err := state.ollie.ReplaceLines(at, at, state.wordInput.Text())
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if state.entry.at >= 0 {
+			state.entry.at += 1
+		}
+		state.line = at + 1
+		state.quitWarned = false
+		fmt.Printf("%d:%d\n", state.line, len(state.wordInput.Text()))
+	}
+	return nil
+}
+
+// startEntry makes the lines typed next go in after line at, or in place of
+// the lines in r for 'c'. The lines are numbered from 1, line 0 puts them
+// before the first line.
+func startEntry(state *State, cmd string, r lineRange) error {
+	switch cmd {
+	case APPEND:
+		state.entry = textEntry{at: r.last}
+	case INSERT:
+		state.entry = textEntry{at: max(r.last-1, 0)}
+	case CHANGE:
+		err := state.ollie.ReplaceLines(r.first-1, r.last)
+		if err != nil {
+			return err
+		}
+		state.entry = textEntry{at: r.first - 1, withCommand: true}
+		state.line = r.first - 1
 	}
 	return nil
 }
 
 // Currently utilizing the go stdlib implementation of the boyer-moore string searching algorithm
-func searchLinesBuffer(state *State, text string) (bool, error) {
+func searchLinesBuffer(state *State, r lineRange, text string) (bool, error) {
 	if text == "" {
 		return false, fmt.Errorf("need a text string to search the buffer for")
 	}
 	found := false
 	sf := search.MakeStringFinder(text)
-	for i, line := range state.ollie.Lines {
+	lines := state.ollie.Lines
+	lines.Range(r.first-1, r.last, func(i int, line string) bool {
 		if sf.Next(line) != -1 {
 			fmt.Printf("%d:%s\n", i+1, line)
 			found = true
 		}
-	}
+		return true
+	})
 	if found == false {
 		fmt.Printf("%s not found in buffer\n", text)
 	}
 	return found, nil
 }
 
-func writeToDisk(state *State, param string) error {
-	if param != "" {
-		state.ollie.Name = param
-		err := state.ollie.CreateFile()
-		if err != nil {
+// Load state.ollie from disk. If another session is already editing the file
+// offer to open it read-only instead.
+func openFile(state *State) error {
+	readOnly := state.ollie.ReadOnly
+	err := state.ollie.CreateFile()
+
+	// Ask for the passphrase of an encrypted file, a few times in case of
+	// typos
+	for tries := 0; tries < 3; tries++ {
+		if !errors.Is(err, olliefile.ErrNeedPassphrase) && !errors.Is(err, olliefile.ErrPassphrase) {
+			break
+		}
+		if tries > 0 || errors.Is(err, olliefile.ErrPassphrase) {
+			fmt.Println(olliefile.ErrPassphrase)
+		}
+		pass, ok := readPassphrase(state, fmt.Sprintf("passphrase for %s: ", state.ollie.Name))
+		if !ok {
 			return err
 		}
-	} else if state.ollie.FileHandle == nil && state.ollie.Name != "" {
-		err := state.ollie.CreateFile()
+		state.ollie.SetPassphrase(pass)
+		err = state.ollie.CreateFile()
+	}
+
+	var locked *olliefile.LockError
+	if err == nil && state.ollie.ReadOnly && !readOnly {
+		fmt.Printf("%s is not writable, opened read-only. Use 'w <file>' to save it under a new name\n",
+			state.ollie.Name)
+	}
+	if err == nil && state.ollie.Hex {
+		fmt.Printf("%s looks binary, opened in hex mode. Use 'hex' to view it and 'patch' to change bytes\n",
+			state.ollie.Name)
+	}
+	if !errors.As(err, &locked) {
+		return err
+	}
+
+	fmt.Println(locked)
+	fmt.Print("open it read-only? (y/n) ")
+	if !state.wordInput.Scan() || strings.TrimSpace(state.wordInput.Text()) != "y" {
+		return err
+	}
+	state.ollie.ReadOnly = true
+	err = state.ollie.CreateFile()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("opened %s read-only\n", state.ollie.Name)
+	return nil
+}
+
+// Hex dumps show 16 rows unless asked for more
+const hexDumpRows = 16
+
+// Print rows of the hex view of a binary file. param is an optional offset
+// to start from and the number of rows to show.
+func hexDump(state *State, param string) error {
+	if !state.ollie.Hex {
+		return fmt.Errorf("%s is not open in hex mode", state.ollie.Name)
+	}
+
+	var offset int64
+	rows := hexDumpRows
+	fields := strings.Fields(param)
+	if len(fields) > 0 {
+		off, err := strconv.ParseInt(fields[0], 0, 64)
+		if err != nil || off < 0 {
+			return fmt.Errorf("invalid offset %q", fields[0])
+		}
+		offset = off
+	}
+	if len(fields) > 1 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of rows %q", fields[1])
+		}
+		rows = n
+	}
+
+	lines := state.ollie.Lines
+	from := min(int(offset/olliefile.HexRowBytes), lines.Len())
+	lines.Range(from, min(from+rows, lines.Len()), func(_ int, row string) bool {
+		fmt.Println(row)
+		return true
+	})
+	return nil
+}
+
+// Overwrite bytes of a binary file, param is the offset followed by the new
+// bytes in hex, for example "0x10 de ad be ef"
+func patchBytes(state *State, param string) error {
+	off, data, ok := strings.Cut(strings.TrimSpace(param), " ")
+	if !ok {
+		return fmt.Errorf("'patch' needs an offset and the bytes to write in hex")
+	}
+	offset, err := strconv.ParseInt(off, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid offset %q", off)
+	}
+	bytes, err := hex.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return fmt.Errorf("invalid bytes %q: %w", data, err)
+	}
+
+	err = state.ollie.PatchBytes(offset, bytes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("patched %d bytes at %#x\n", len(bytes), offset)
+	return nil
+}
+
+// Offer to recover the changes in a swap file left behind by a session that
+// didn't exit cleanly
+func recoverSwap(state *State) error {
+	name, ok := state.ollie.FindSwap()
+	if !ok {
+		return nil
+	}
+
+	fmt.Printf("found swap file %s, %s may have unsaved changes from an earlier session\n",
+		name, state.ollie.Name)
+	for {
+		fmt.Print("(r)ecover, (c)ompare with disk or (d)iscard? ")
+		if !state.wordInput.Scan() {
+			return nil
+		}
+		switch strings.TrimSpace(state.wordInput.Text()) {
+		case "r":
+			err := state.ollie.Recover()
+			if err != nil {
+				return fmt.Errorf("recover %s: %w", name, err)
+			}
+			fmt.Printf("recovered %d lines, use 'w' to save them\n", state.ollie.Lines.Len())
+			return nil
+		case "c":
+			err := compareSwap(state)
+			if err != nil {
+				fmt.Println(err)
+			}
+		case "d":
+			return state.ollie.DiscardSwap()
+		}
+	}
+}
+
+// Print the lines that differ between the file on disk and the recovered
+// buffer, disk lines are prefixed with '-' and recovered ones with '+'
+func compareSwap(state *State) error {
+	recovered, warning, err := state.ollie.ReadSwap()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Println("warning:", warning)
+	}
+
+	if !printDiff(state.ollie.Lines, recovered) {
+		fmt.Println("swap file matches the file on disk")
+	}
+	return nil
+}
+
+// Print the block of lines that differs between two buffers, the lines from
+// old prefixed with '-' and the ones from new with '+'. Returns false if they
+// are the same.
+func printDiff(old, new olliefile.Buffer) bool {
+	from, toOld, toNew := olliefile.DiffRange(old, new)
+	if from == toOld && from == toNew {
+		return false
+	}
+	old.Range(from, toOld, func(i int, line string) bool {
+		fmt.Printf("-%d:%s\n", i+1, line)
+		return true
+	})
+	new.Range(from, toNew, func(i int, line string) bool {
+		fmt.Printf("+%d:%s\n", i+1, line)
+		return true
+	})
+	return true
+}
+
+// Re-read the file from disk and show what changed compared to the buffer.
+// Unsaved changes to a binary or mapped file are only thrown away when
+// forced, reloading those can't be undone.
+func reloadFile(state *State, force bool) error {
+	old, err := state.ollie.Reload(force)
+	if errors.Is(err, olliefile.ErrUnsavedChanges) {
+		return fmt.Errorf("%s has unsaved changes that can't be undone after reloading, use 'E!' to reload anyway", state.ollie.Name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reloaded %s, %d lines\n", state.ollie.Name, state.ollie.Lines.Len())
+	if !printDiff(old, state.ollie.Lines) {
+		fmt.Println("no changes")
+	}
+	return nil
+}
+
+// Read a passphrase from the terminal without showing it. If it would be
+// shown it isn't asked for at all.
+func readPassphrase(state *State, prompt string) (string, bool) {
+	echoOn, err := echoOff()
+	if err != nil {
+		fmt.Printf("%v, not asking for a passphrase that would be shown\n", err)
+		return "", false
+	}
+	fmt.Print(prompt)
+	ok := state.wordInput.Scan()
+	echoOn()
+	fmt.Println()
+	return state.wordInput.Text(), ok
+}
+
+// Set the passphrase the buffer is encrypted with, asking for it twice. An
+// empty passphrase saves the file unencrypted.
+func setPassphrase(state *State) error {
+	pass, ok := readPassphrase(state, "new passphrase, empty to turn encryption off: ")
+	if !ok {
+		return nil
+	}
+	if pass != "" {
+		again, _ := readPassphrase(state, "again: ")
+		if again != pass {
+			return fmt.Errorf("passphrases don't match, nothing changed")
+		}
+	}
+
+	err := state.ollie.SetPassphrase(pass)
+	if err != nil {
+		return err
+	}
+	if pass == "" {
+		fmt.Println(state.ollie.Name, "will be saved unencrypted")
+	} else {
+		fmt.Println(state.ollie.Name, "will be saved encrypted")
+	}
+	return nil
+}
+
+// Show whether the buffer is read-only or turn it on or off
+func readOnlyCommand(state *State, param string) error {
+	switch param {
+	case "":
+		if state.ollie.ReadOnly {
+			fmt.Println(state.ollie.Name, "is read-only")
+		} else {
+			fmt.Println(state.ollie.Name, "is writable")
+		}
+		return nil
+	case "on", "off":
+		err := state.ollie.SetReadOnly(param == "on")
 		if err != nil {
 			return err
 		}
+		fmt.Printf("read-only %s\n", param)
+		return nil
+	}
+	return fmt.Errorf("valid parameter for read-only is 'on' or 'off'")
+}
+
+// Lists the backups of the file with a number for each, or with a number
+// restores that backup into the buffer. Like reloading, that only throws
+// away unsaved changes to a binary or mapped file when forced.
+func backupCommand(state *State, param string, force bool) error {
+	backups, err := state.ollie.Backups()
+	if err != nil {
+		return err
+	}
+	if param == "" {
+		if len(backups) == 0 {
+			fmt.Println("no backups of", state.ollie.Name)
+		}
+		for i, b := range backups {
+			when := ""
+			if fi, err := os.Stat(b); err == nil {
+				when = fi.ModTime().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d: %s %s\n", i+1, b, when)
+		}
+		return nil
 	}
 
-	bytes, err := state.ollie.WriteFile()
+	n, err := strconv.Atoi(param)
+	if err != nil || n < 1 || n > len(backups) {
+		return fmt.Errorf("no backup %q, 'backup' lists them", param)
+	}
+	old := state.ollie.Lines.Snapshot()
+	err = state.ollie.RestoreBackup(backups[n-1], force)
+	if errors.Is(err, olliefile.ErrUnsavedChanges) {
+		return fmt.Errorf("%s has unsaved changes that can't be undone after restoring, use 'backup! %d' to restore anyway", state.ollie.Name, n)
+	}
 	if err != nil {
 		return err
+	}
+	fmt.Printf("restored %s, %d lines\n", backups[n-1], state.ollie.Lines.Len())
+	if !printDiff(old, state.ollie.Lines) {
+		fmt.Println("no changes")
+	}
+	return nil
+}
+
+func writeToDisk(state *State, lines lineRange, param string, force bool) error {
+	// Writing some of the lines or naming another file writes a copy, the
+	// file being edited stays the same. Use saveas to rename the buffer.
+	if lines.given || (param != "" && param != state.ollie.Name) {
+		name := param
+		if name == "" {
+			name = state.ollie.Name
+		}
+		bytes, err := state.ollie.WriteRange(name, lines.first-1, lines.last)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d bytes to %s\n", bytes, name)
+		return nil
+	}
+
+	if state.ollie.ReadOnly {
+		return fmt.Errorf("%w, use 'w <file>' to save it under a new name", olliefile.ErrReadOnly)
+	}
+
+	write := state.ollie.WriteFile
+	if force {
+		write = state.ollie.ForceWriteFile
+	}
+	bytes, err := write()
+	if errors.Is(err, olliefile.ErrModified) {
+		return fmt.Errorf("%w, use 'w!' to overwrite it or 'E' to reload it", err)
+	} else if err != nil && state.ollie.Compression == olliefile.Bzip2 {
+		return fmt.Errorf("%w, use 'compress gzip' or 'compress none' first", err)
+	} else if err != nil {
+		return err
 	} else {
 		fmt.Printf("Wrote %d bytes to %s\n", bytes, state.ollie.Name)
 	}
@@ -141,6 +557,62 @@ func writeToDisk(state *State, param string) error {
 	return nil
 }
 
+// appendToDisk adds the lines to the end of a file, the one being edited if
+// no name is given
+func appendToDisk(state *State, lines lineRange, param string) error {
+	name := param
+	if name == "" {
+		name = state.ollie.Name
+	}
+	bytes, err := state.ollie.AppendRange(name, lines.first-1, lines.last)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d bytes to the end of %s\n", bytes, name)
+	return nil
+}
+
+// readIntoBuffer inserts a file or, for "!command", the output of a shell
+// command after the addressed line, the end of the buffer by default
+func readIntoBuffer(state *State, lines lineRange, param string) error {
+	if param == "" {
+		return fmt.Errorf("'r' needs a file name or !command to read")
+	}
+	at := lines.last
+
+	var n int
+	var err error
+	if command, ok := strings.CutPrefix(param, "!"); ok {
+		var res []byte
+		res, err = shellOutput(command)
+		if err == nil {
+			n, err = state.ollie.InsertFrom(at, bytes.NewReader(res))
+		}
+	} else {
+		n, err = state.ollie.InsertFile(at, param)
+	}
+	if err != nil {
+		return err
+	}
+
+	state.line = at + n
+	fmt.Printf("Read %d lines from %s\n", n, param)
+	return nil
+}
+
+// saveAs renames the buffer and saves it under the new name
+func saveAs(state *State, param string) error {
+	if param == "" {
+		return fmt.Errorf("'saveas' needs the name to save the file as")
+	}
+	bytes, err := state.ollie.SaveAs(param)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d bytes to %s\n", bytes, state.ollie.Name)
+	return nil
+}
+
 // Spellchecking a single word
 //
 // This sends the current text in the state bufio scanner to the spelling
@@ -170,4 +642,4 @@ func getSpellcheckSuggestions(state *State) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
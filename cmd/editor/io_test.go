@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// typeText runs a text entry command and types the lines after it, ending
+// with '.' like in append mode
+func typeText(state *State, cmd string, lines ...string) error {
+	state.entry = textEntry{at: -1}
+	state.ollie.BeginChange()
+	if err := runCommand(state, cmd); err != nil {
+		return err
+	}
+	input := strings.Join(append(lines, COMMAND_MODE), "\n")
+	state.wordInput = bufio.NewScanner(strings.NewReader(input))
+	return getWords(state)
+}
+
+func TestTextEntry(t *testing.T) {
+	tests := []struct {
+		cmd   string
+		typed []string
+		lines int      // lines in the buffer afterwards
+		from  int      // the lines from here on start with want
+		want  []string // around the lines typed
+		line  int      // the current line afterwards
+	}{
+		{"a", []string{"x"}, 11, 9, []string{"line 10", "x"}, 11},
+		{"2a", []string{"x", "y"}, 12, 1, []string{"line 2", "x", "y", "line 3"}, 4},
+		{"0a", []string{"x"}, 11, 0, []string{"x", "line 1"}, 1},
+		{"I", []string{"x"}, 11, 3, []string{"line 4", "x", "line 5"}, 5},
+		{"1I", []string{"x", "y"}, 12, 0, []string{"x", "y", "line 1"}, 2},
+		{"2,9c", []string{"x"}, 3, 0, []string{"line 1", "x", "line 10"}, 2},
+		{"$c", nil, 9, 7, []string{"line 8", "line 9"}, 9},
+	}
+	for _, tt := range tests {
+		state := testState()
+		if err := typeText(state, tt.cmd, tt.typed...); err != nil {
+			t.Errorf("%q = %v", tt.cmd, err)
+			continue
+		}
+		buf := state.ollie.Lines
+		if buf.Len() != tt.lines {
+			t.Errorf("%q typing %q left %d lines, want %d", tt.cmd, tt.typed, buf.Len(), tt.lines)
+			continue
+		}
+		if got := buf.Slice(tt.from, tt.from+len(tt.want)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q typing %q left %q, want %q", tt.cmd, tt.typed, got, tt.want)
+		}
+		if state.line != tt.line {
+			t.Errorf("%q typing %q left the current line at %d, want %d", tt.cmd, tt.typed, state.line, tt.line)
+		}
+		if s := state.ollie.Stats(); s.Lines != tt.lines {
+			t.Errorf("%q typing %q counted %d lines", tt.cmd, tt.typed, s.Lines)
+		}
+	}
+
+	// Changing lines is undone in one go with the lines typed
+	state := testState()
+	if err := typeText(state, "2,3c", "x"); err != nil {
+		t.Fatal(err)
+	}
+	state.ollie.Undo()
+	if got := state.ollie.Lines.Slice(0, 4); fmt.Sprint(got) != "[line 1 line 2 line 3 line 4]" {
+		t.Errorf("undoing c left %q", got)
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	state := testState()
+	if err := runCommand(state, `2r !printf 'a|b\nc\n' | tr '|' ' '; echo oops >&2`); err != nil {
+		t.Fatal(err)
+	}
+	if got := state.ollie.Lines.Slice(0, 5); fmt.Sprint(got) != "[line 1 line 2 a b c line 3]" {
+		t.Errorf("reading the command's output left %q", got)
+	}
+	if state.line != 4 {
+		t.Errorf("current line = %d, want 4", state.line)
+	}
+
+	// Nothing is read from a command that fails
+	if err := runCommand(state, "r !echo partial; exit 3"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("failing command = %v, want its exit status", err)
+	}
+	if n := state.ollie.Lines.Len(); n != 12 {
+		t.Errorf("failing command left %d lines, want 12", n)
+	}
+}
@@ -0,0 +1,98 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Width lines printed with 'l' are folded at
+const listWidth = 72
+
+// printLines prints the lines in r the way the ed command of the same name
+// does: 'p' as they are, 'n' after their line number and a tab and 'l'
+// unambiguously. The last line printed becomes the current line.
+func printLines(state *State, r lineRange, how string) {
+	state.ollie.Lines.Range(r.first-1, r.last, func(i int, line string) bool {
+		switch how {
+		case NUMBER_LINES:
+			fmt.Printf("%d\t%s\n", i+1, line)
+		case LIST_LINES:
+			fmt.Print(listLine(line))
+		default:
+			fmt.Println(line)
+		}
+		return true
+	})
+	state.line = r.last
+}
+
+// listLine shows line the way 'l' prints it. Backslashes and control
+// characters are escaped, invalid bytes are shown in octal, long lines are
+// folded with a '\' and the end of the line is marked with '$'.
+func listLine(line string) string {
+	var b strings.Builder
+	width := 0
+	put := func(s string) {
+		n := utf8.RuneCountInString(s)
+		if width+n >= listWidth {
+			b.WriteString("\\\n")
+			width = 0
+		}
+		b.WriteString(s)
+		width += n
+	}
+
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			put(fmt.Sprintf("\\%03o", line[i]))
+		case r == '\\':
+			put("\\\\")
+		case r == '\a':
+			put("\\a")
+		case r == '\b':
+			put("\\b")
+		case r == '\f':
+			put("\\f")
+		case r == '\r':
+			put("\\r")
+		case r == '\t':
+			put("\\t")
+		case r == '\v':
+			put("\\v")
+		case !unicode.IsPrint(r):
+			for _, c := range []byte(line[i : i+size]) {
+				put(fmt.Sprintf("\\%03o", c))
+			}
+		default:
+			put(line[i : i+size])
+		}
+		i += size
+	}
+	b.WriteString("$\n")
+	return b.String()
+}
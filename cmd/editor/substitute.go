@@ -0,0 +1,223 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errNoSubstitution is returned by substitute when re matched nothing, which
+// a global command skips over like ed does
+var errNoSubstitution = errors.New("no match")
+
+// A piece of the replacement in s/re/replacement/, either text or the group
+// of the match to put in its place. Group 0 is the whole match.
+type replacementPart struct {
+	text  string
+	group int
+}
+
+// parseReplacement reads the replacement of a substitution. '&' is the text
+// that matched and \1 to \9 the text the groups matched, a backslash before
+// any other character, like \& or \/, makes it stand for itself.
+func parseReplacement(rep string) []replacementPart {
+	var parts []replacementPart
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			parts = append(parts, replacementPart{text: text.String(), group: -1})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(rep); i++ {
+		c := rep[i]
+		switch {
+		case c == '&':
+			flush()
+			parts = append(parts, replacementPart{group: 0})
+		case c == '\\' && i+1 < len(rep) && rep[i+1] >= '1' && rep[i+1] <= '9':
+			flush()
+			parts = append(parts, replacementPart{group: int(rep[i+1] - '0')})
+			i += 1
+		case c == '\\' && i+1 < len(rep):
+			text.WriteByte(rep[i+1])
+			i += 1
+		default:
+			text.WriteByte(c)
+		}
+	}
+	flush()
+	return parts
+}
+
+// expand appends the replacement for the match in line to b, match holds
+// the start and end of the whole match and each group like
+// regexp.FindStringSubmatchIndex returns them
+func expand(b *strings.Builder, parts []replacementPart, line string, match []int) {
+	for _, p := range parts {
+		if p.group < 0 {
+			b.WriteString(p.text)
+			continue
+		}
+		if 2*p.group+1 < len(match) && match[2*p.group] >= 0 {
+			b.WriteString(line[match[2*p.group]:match[2*p.group+1]])
+		}
+	}
+}
+
+// substitution is a parsed s/re/replacement/flags command
+type substitution struct {
+	pattern     string
+	replacement string
+	global      bool   // g, replace every match instead of one
+	nth         int    // replace the nth match, or every one from it with g
+	print       string // p, n or l to print the last line changed
+}
+
+// parseSubstitution reads the "/re/replacement/flags" of an s command. Like
+// ed the delimiter can be any character that isn't a letter, digit, space or
+// backslash, and leaving off the last one prints the line changed.
+func parseSubstitution(param string) (substitution, error) {
+	sub := substitution{nth: 1}
+	if param == "" {
+		return sub, fmt.Errorf("'s' needs /re/replacement/")
+	}
+	delim := param[0]
+	if err := checkDelimiter(delim); err != nil {
+		return sub, err
+	}
+
+	var rest string
+	var closed bool
+	sub.pattern, rest, closed = splitDelimited(param[1:], delim)
+	if !closed {
+		return sub, fmt.Errorf("missing delimiter after %s", param)
+	}
+	sub.replacement, rest, closed = splitDelimited(rest, delim)
+	if !closed {
+		sub.print = SPELLCHECK
+	}
+
+	for i := 0; i < len(rest); i++ {
+		switch c := rest[i]; {
+		case c == 'g':
+			sub.global = true
+		case c == 'p' || c == 'n' || c == 'l':
+			sub.print = string(c)
+		case c >= '1' && c <= '9':
+			n := int(c - '0')
+			for i+1 < len(rest) && rest[i+1] >= '0' && rest[i+1] <= '9' {
+				i += 1
+				n = n*10 + int(rest[i]-'0')
+			}
+			sub.nth = n
+		default:
+			return sub, fmt.Errorf("unknown flag %q for 's'", c)
+		}
+	}
+	return sub, nil
+}
+
+// substitute runs s/re/replacement/flags on the lines in r. Every line
+// changed is part of the same undo step. The last line changed becomes the
+// current line.
+func substitute(state *State, r lineRange, param string) error {
+	sub, err := parseSubstitution(param)
+	if err != nil {
+		return err
+	}
+	m, err := compilePattern(state, sub.pattern)
+	if err != nil {
+		return err
+	}
+	// Like ed a replacement of just '%' is the last one used
+	if sub.replacement == "%" {
+		sub.replacement = state.lastReplacement
+	}
+	state.lastReplacement = sub.replacement
+	parts := parseReplacement(sub.replacement)
+	re := m.Regexp()
+
+	type changed struct {
+		i    int
+		line string
+	}
+	var lines []changed
+	count := 0
+	state.ollie.Lines.Range(r.first-1, r.last, func(i int, line string) bool {
+		if !m.MatchString(line) {
+			return true
+		}
+
+		var b strings.Builder
+		last, n, made := 0, 0, 0
+		for _, match := range re.FindAllStringSubmatchIndex(line, -1) {
+			n += 1
+			if n < sub.nth || (n > sub.nth && !sub.global) {
+				continue
+			}
+			b.WriteString(line[last:match[0]])
+			expand(&b, parts, line, match)
+			last = match[1]
+			made += 1
+		}
+		if made > 0 {
+			b.WriteString(line[last:])
+			lines = append(lines, changed{i, b.String()})
+			count += made
+		}
+		return true
+	})
+	if count == 0 {
+		return fmt.Errorf("%w for %s", errNoSubstitution, m)
+	}
+
+	for _, c := range lines {
+		err := state.ollie.ReplaceLines(c.i, c.i+1, c.line)
+		if err != nil {
+			return err
+		}
+	}
+
+	last := lines[len(lines)-1].i + 1
+	state.line = last
+	if sub.print != "" {
+		printLines(state, lineRange{first: last, last: last}, sub.print)
+	}
+	// A global command makes its substitutions one line at a time, reporting
+	// each would drown out everything else
+	if !state.inGlobal {
+		fmt.Printf("%s on %s\n", plural(count, "substitution"), plural(len(lines), "line"))
+	}
+	return nil
+}
+
+// plural formats a count of things
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// substituteCommand runs an s command the way execIoCommand does
+func substituteCommand(state *State, command string) error {
+	lines, cmd, param, err := parseCommandArgs(state, command)
+	if err != nil {
+		return err
+	}
+	if cmd != SUBSTITUTE {
+		return fmt.Errorf("%q is command %q", command, cmd)
+	}
+	lines, err = commandAddressing[cmd].resolve(lines, state)
+	if err != nil {
+		return err
+	}
+	state.ollie.BeginChange()
+	return substitute(state, lines, param)
+}
+
+func TestSubstitute(t *testing.T) {
+	tests := []struct {
+		cmd         string
+		first, last int
+		want        []string
+	}{
+		{"s/line/LINE/", 5, 5, []string{"LINE 5"}},
+		{"1,3s/(l)(i)ne/\\2\\1&/", 1, 3, []string{"illine 1", "illine 2", "illine 3"}},
+		{"s/[a-z]/_/3", 5, 5, []string{"li_e 5"}},
+		{"s/[a-z]/_/2g", 5, 5, []string{"l___ 5"}},
+		{"s|5|\\||", 5, 5, []string{"line |"}},
+		{",s/1$/one/", 1, 10, []string{"line one", "line 2", "line 3", "line 4", "line 5",
+			"line 6", "line 7", "line 8", "line 9", "line 10"}},
+	}
+	for _, tt := range tests {
+		state := testState()
+		if err := substituteCommand(state, tt.cmd); err != nil {
+			t.Errorf("%q = %v", tt.cmd, err)
+			continue
+		}
+		got := state.ollie.Lines.Slice(tt.first-1, tt.last)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q changed the lines to %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	for _, cmd := range []string{"s/nope/x/", "s//x/", "s/a", "sa/b/c/", "s/a/b/q"} {
+		if err := substituteCommand(testState(), cmd); err == nil {
+			t.Errorf("%q succeeded", cmd)
+		}
+	}
+
+	// An empty pattern is the last one and every line changed is undone at
+	// once
+	state := testState()
+	if err := substituteCommand(state, ",s/line/l/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := substituteCommand(state, "2s//%/"); err == nil {
+		t.Error("reusing the pattern found no line left to change")
+	}
+	state.ollie.Undo()
+	if got := state.ollie.Lines.Line(9); got != "line 10" {
+		t.Errorf("after undo the last line is %q", got)
+	}
+	if err := substituteCommand(state, "2s//%/"); err != nil || state.ollie.Lines.Line(1) != "l 2" {
+		t.Errorf("s//%%/ = %v, line 2 is %q", err, state.ollie.Lines.Line(1))
+	}
+}
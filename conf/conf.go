@@ -38,6 +38,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -82,6 +83,9 @@ var confParams = map[string]Tokens{
 	"spellcheck":     TokenString,
 	"dictionary":     TokenString,
 	"append-default": TokenString,
+	"backup":         TokenString,
+	"backup-dir":     TokenString,
+	"backup-keep":    TokenInteger,
 }
 
 // Token holds the Token type and the value of the token found in the stream
@@ -98,7 +102,8 @@ type Tokenizer struct {
 	lines       *bufio.Scanner
 	currentLine string
 	location    int
-	column      int // Used for position in the line
+	column      int  // Used for position in the line
+	afterEquals bool // The rest of the line is a value
 }
 
 type Parser struct {
@@ -107,6 +112,27 @@ type Parser struct {
 	location  int
 }
 
+// Get returns the value of key and whether it was set. It is safe to call on
+// nil settings, which have nothing set.
+func (s *Settings) Get(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	val, ok := s.settings[key]
+	return val, ok
+}
+
+// GetInt returns the value of a numeric key, the parser has already made sure
+// it is a number
+func (s *Settings) GetInt(key string) (int, bool) {
+	val, ok := s.Get(key)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(val)
+	return n, err == nil
+}
+
 func parserTime() string {
 	return time.Now().Format(timeFormat)
 }
@@ -147,94 +173,75 @@ func ParseConfig(rd io.Reader) (*Settings, error) {
 }
 
 // We iterate the config file lines either returning error tokens or
-// passing to tokenizeLine and returning the token found from there.
+// passing to tokenizeLine and returning the token found from there. A line
+// holds more than one token so we keep returning tokens from the current
+// line until it is used up.
 func (t *Tokenizer) GetNextToken() Token {
-	lineNumber := 0
-	for t.lines.Scan() {
+	for t.column >= len(t.currentLine) {
+		if !t.lines.Scan() {
+			if err := t.lines.Err(); err != nil {
+				return Token{Type: TokenError, Value: err.Error(), Location: t.location}
+			}
+			return Token{Type: TokenEOF, Value: ""}
+		}
 		log.Printf("%s: parsing line: %s", parserTime(), t.lines.Text())
 
 		// This will be used for error reporting where syntax errors could occur during
 		// parsing
-		lineNumber += 1
+		t.location += 1
 		t.currentLine = t.lines.Text()
-		t.location = lineNumber
-
-		if len(strings.TrimSpace(t.currentLine)) == 0 {
-			continue
-		}
-
-		return t.tokenizeLine(t.currentLine)
+		t.column = 0
+		t.afterEquals = false
 	}
 
-	if err := t.lines.Err(); err != nil {
-		return Token{Type: TokenError, Value: err.Error(), Location: lineNumber}
-	}
-
-	return Token{Type: TokenEOF, Value: ""}
+	return t.tokenizeLine(t.currentLine)
 }
 
-// tokenizeLine will parse a line into tokens for the parser
+// tokenizeLine returns the next token in line starting at t.column
 func (t *Tokenizer) tokenizeLine(line string) Token {
-	t.column = 0
 	for t.column < len(line) {
 		sym := line[t.column]
 
 		switch {
 		case sym == '#':
-			t.column += 1
-			return Token{Type: TokenComment, Value: line, Location: t.column}
+			t.column = len(line)
+			return Token{Type: TokenComment, Value: line, Location: t.location}
 		case unicode.IsSpace(rune(sym)):
 			t.column += 1
 		case sym == '=':
 			t.column += 1
-			return Token{Type: TokenEquals, Value: "=", Location: t.column}
-		case sym == '\n':
-			t.column += 1
-			return Token{Type: TokenNL, Value: "\n", Location: t.column}
+			t.afterEquals = true
+			return Token{Type: TokenEquals, Value: "=", Location: t.location}
 		// If it isnt any of the other symbols for the grammar we strip the
 		// key/value pairs here.
-		case unicode.IsLetter(rune(sym)) || unicode.IsDigit(rune(sym)) || sym == '-':
+		case t.afterEquals || unicode.IsLetter(rune(sym)) || unicode.IsDigit(rune(sym)) || sym == '-':
 			log.Println("tokenizer line should be val or key:", line)
 			return findKeyOrValueInLine(line, t)
 		default:
 			t.column += 1
-			return Token{Type: TokenError, Value: string(sym), Location: t.column}
+			return Token{Type: TokenError, Value: string(sym), Location: t.location}
 		}
 	}
 	return Token{}
 }
 
+// Everything after the "=" up to the end of the line is the value, before it
+// keys are made of letters, digits and "-"
 func findKeyOrValueInLine(line string, t *Tokenizer) Token {
-
-	fl := strings.Replace(line, " ", "", -1)
-
-	end := 0
-	begin := 0
-	for end < len(fl) {
-		end++
-		if fl[end] == '=' {
-			break
-		}
+	begin := t.column
+	if t.afterEquals {
+		t.column = len(line)
+		return Token{Type: TokenValue, Value: strings.TrimSpace(line[begin:]), Location: t.location}
 	}
 
-	// We have reached either something that isnt a character/digit or a valid separating symbol for keys "-"
-	// test it for either being =val or key=
-	val := fl[begin : end+1]
-	if strings.Contains(val, "=") {
-
-		k, v, _ := strings.Cut(val, "=")
-		key := strings.TrimSpace(k)
-		value := strings.TrimSpace(v)
-
-		if len(value) > 0 {
-			return Token{Type: TokenValue, Value: value}
-		}
-
-		if len(value) > 0 {
-			return Token{Type: TokenKey, Value: key}
+	for t.column < len(line) {
+		sym := rune(line[t.column])
+		if !unicode.IsLetter(sym) && !unicode.IsDigit(sym) && sym != '-' {
+			break
 		}
+		t.column += 1
 	}
-	return Token{}
+	return Token{Type: TokenKey, Value: line[begin:t.column], Location: t.location}
 }
 
 func (p *Parser) getNextToken() Token {
@@ -257,14 +264,22 @@ func (p *Parser) Parse() (*Settings, error) {
 			return conf, nil
 		case TokenKey:
 			key := token.Value
-			_, ok := confParams[key]
-			if ok {
-				nt := p.getNextToken()
-				if nt.Type == TokenEquals {
-					val := p.getNextToken()
-					conf.settings[key] = val.Value
-				}
+			nt := p.getNextToken()
+			if nt.Type != TokenEquals {
+				return nil, fmt.Errorf("expected '=' after %s at %d", key, token.Location)
+			}
+			typ, ok := confParams[key]
+			if !ok {
+				continue
+			}
+			val := p.getNextToken()
+			if val.Type != TokenValue {
+				return nil, fmt.Errorf("missing value for %s at %d", key, token.Location)
+			}
+			if _, err := strconv.Atoi(val.Value); typ == TokenInteger && err != nil {
+				return nil, fmt.Errorf("%s must be a number at %d", key, token.Location)
 			}
+			conf.settings[key] = val.Value
 		case TokenValue:
 			continue
 		case TokenEquals:
@@ -0,0 +1,40 @@
+package conf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := `# Default configuration file for the ollie editor
+spellcheck = true
+dictionary = /usr/share/dict/words
+
+unknown-key = 1
+backup=numbered
+backup-keep = 5
+`
+	settings, err := ParseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseConfig() = %v", err)
+	}
+
+	want := map[string]string{
+		"spellcheck":  "true",
+		"dictionary":  "/usr/share/dict/words",
+		"backup":      "numbered",
+		"backup-keep": "5",
+	}
+	for key, val := range want {
+		if got, ok := settings.Get(key); !ok || got != val {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, ok, val)
+		}
+	}
+	if _, ok := settings.Get("unknown-key"); ok {
+		t.Error("unknown keys should be ignored")
+	}
+
+	if _, err := ParseConfig(strings.NewReader("backup-keep = lots\n")); err == nil {
+		t.Error("ParseConfig() accepted a non-numeric backup-keep")
+	}
+}
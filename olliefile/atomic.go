@@ -0,0 +1,133 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Symlinks are followed when saving so the link itself stays in place and the
+// file it points at is replaced. This bounds how deep we follow them.
+const maxSymlinks = 40
+
+// resolveTarget follows name through any symlinks and returns the path of the
+// file that should actually be replaced on save. A dangling link resolves to
+// the path it points at so saving creates that file.
+func resolveTarget(name string) (string, error) {
+	target := name
+	for i := 0; i < maxSymlinks; i++ {
+		fi, err := os.Lstat(target)
+		if errors.Is(err, os.ErrNotExist) {
+			return target, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return target, nil
+		}
+
+		link, err := os.Readlink(target)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(target), link)
+		}
+		target = link
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", name)
+}
+
+// writeAtomic saves whatever fn writes to name without ever leaving a partial
+// file behind. The data goes to a temporary file in the same directory, which
+// is synced and then renamed over the original. If anything fails before the
+// rename the original is left untouched.
+//
+// The mode and owner of an existing file are carried over to the new one.
+// It returns the path that was written, which differs from name when name is
+// a symlink.
+func writeAtomic(name string, fn func(w io.Writer) (int, error)) (string, int, error) {
+	target, err := resolveTarget(name)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var perm os.FileMode = 0644
+	info, err := os.Stat(target)
+	if err == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", 0, err
+	}
+
+	dir := filepath.Dir(target)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	bytes, err := fn(w)
+	if err != nil {
+		return target, bytes, err
+	}
+	if err := w.Flush(); err != nil {
+		return target, bytes, err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return target, bytes, err
+	}
+	if info != nil {
+		copyOwner(tmp, info)
+	}
+	if err := tmp.Sync(); err != nil {
+		return target, bytes, err
+	}
+	if err := tmp.Close(); err != nil {
+		return target, bytes, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return target, bytes, err
+	}
+	committed = true
+
+	// Make sure the rename itself survives a crash. Not every platform lets
+	// us sync a directory so failures here are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return target, bytes, nil
+}
@@ -0,0 +1,239 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupMode is how the previous version of a file is kept when saving
+type BackupMode int
+
+const (
+	NoBackup        BackupMode = iota
+	SimpleBackup               // file~
+	NumberedBackup             // file.~1~, file.~2~, ...
+	TimestampBackup            // copies named after the time they were made
+)
+
+// Timestamped backups sort by name in the order they were made
+const backupTimeFormat = "20060102-150405.000"
+
+func (m BackupMode) String() string {
+	switch m {
+	case SimpleBackup:
+		return "simple"
+	case NumberedBackup:
+		return "numbered"
+	case TimestampBackup:
+		return "timestamped"
+	default:
+		return "off"
+	}
+}
+
+// ParseBackupMode accepts the names printed by BackupMode.String
+func ParseBackupMode(name string) (BackupMode, error) {
+	for _, m := range []BackupMode{NoBackup, SimpleBackup, NumberedBackup, TimestampBackup} {
+		if strings.EqualFold(name, m.String()) {
+			return m, nil
+		}
+	}
+	return NoBackup, fmt.Errorf("unknown backup mode %q, expected off, simple, numbered or timestamped", name)
+}
+
+// backupPrefix is the start of the name of every timestamped backup of the
+// file. Backups from many directories can share BackupDir, so the full path
+// of the file is part of the name with '!' in place of the separators.
+func (o *File) backupPrefix() (string, error) {
+	abs, err := filepath.Abs(o.Name)
+	if err != nil {
+		return "", err
+	}
+	dir := o.BackupDir
+	if dir == "" {
+		dir = filepath.Dir(abs)
+	}
+	return filepath.Join(dir, strings.ReplaceAll(abs, string(filepath.Separator), "!")+"."), nil
+}
+
+// numberedBackups returns the numbered backups of name and their numbers,
+// lowest first
+func numberedBackups(name string) ([]string, []int) {
+	matches, _ := filepath.Glob(globEscape(name) + ".~*~")
+	var numbers []int
+	for _, m := range matches {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(m, name+".~"), "~"))
+		if err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	slices.Sort(numbers)
+	names := make([]string, len(numbers))
+	for i, n := range numbers {
+		names[i] = fmt.Sprintf("%s.~%d~", name, n)
+	}
+	return names, numbers
+}
+
+// timestampedBackups returns the timestamped backups starting with prefix,
+// oldest first. Only the time has to follow prefix, the backups of a file
+// whose name starts with this one's don't count.
+func timestampedBackups(prefix string) []string {
+	matches, _ := filepath.Glob(globEscape(prefix) + "*")
+	var names []string
+	for _, m := range matches {
+		_, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, prefix))
+		if err == nil {
+			names = append(names, m)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// makeBackup copies the file on disk to a backup before it is overwritten,
+// the way o.Backup asks for, and removes backups past o.BackupKeep. The copy
+// is of the bytes on disk so it is in whatever format the file was saved in.
+func (o *File) makeBackup(target string) error {
+	// Backing up the unencrypted file we are about to replace with an
+	// encrypted one would leave a copy of the text around
+	if o.Backup == NoBackup || (o.crypt != nil && !isEncrypted(target)) {
+		return nil
+	}
+	src, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var name string
+	var old []string
+	switch o.Backup {
+	case SimpleBackup:
+		name = target + "~"
+	case NumberedBackup:
+		names, numbers := numberedBackups(target)
+		next := 1
+		if len(numbers) > 0 {
+			next = numbers[len(numbers)-1] + 1
+		}
+		name = fmt.Sprintf("%s.~%d~", target, next)
+		old = names
+	case TimestampBackup:
+		prefix, err := o.backupPrefix()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(prefix), 0700); err != nil {
+			return err
+		}
+		name = prefix + time.Now().Format(backupTimeFormat)
+		old = timestampedBackups(prefix)
+	}
+
+	_, _, err = writeAtomic(name, func(w io.Writer) (int, error) {
+		n, err := io.Copy(w, src)
+		return int(n), err
+	})
+	if err == nil {
+		// The backup is as private as the file it came from
+		if fi, serr := src.Stat(); serr == nil {
+			err = os.Chmod(name, fi.Mode().Perm())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", target, err)
+	}
+
+	// old doesn't include the backup just made, so keep one less of them
+	if o.BackupKeep > 0 && len(old) >= o.BackupKeep {
+		for _, b := range old[:len(old)-o.BackupKeep+1] {
+			os.Remove(b)
+		}
+	}
+	return nil
+}
+
+// globEscape quotes the characters filepath.Match treats specially
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Backups returns every backup of the file that can be found, of any kind,
+// oldest first
+func (o *File) Backups() ([]string, error) {
+	target, err := resolveTarget(o.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	if _, err := os.Stat(target + "~"); err == nil {
+		found = append(found, target+"~")
+	}
+	numbered, _ := numberedBackups(target)
+	found = append(found, numbered...)
+	if prefix, err := o.backupPrefix(); err == nil {
+		found = append(found, timestampedBackups(prefix)...)
+	}
+
+	mtimes := make(map[string]time.Time)
+	for _, b := range found {
+		if fi, err := os.Stat(b); err == nil {
+			mtimes[b] = fi.ModTime()
+		}
+	}
+	slices.SortStableFunc(found, func(a, b string) int {
+		return mtimes[a].Compare(mtimes[b])
+	})
+	return found, nil
+}
+
+// RestoreBackup replaces the buffer with the contents of a backup. Like any
+// other edit it can be undone and isn't on disk until the buffer is saved,
+// except in hex mode or for a mapped file where unsaved changes are only
+// thrown away when forced.
+func (o *File) RestoreBackup(name string, force bool) error {
+	if o.ReadOnly {
+		return ErrReadOnly
+	}
+	_, _, err := o.replaceFrom(name, force)
+	return err
+}
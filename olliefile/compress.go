@@ -0,0 +1,161 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Compression is how the file is compressed on disk. The buffer always holds
+// the uncompressed text.
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Bzip2 // can be read but not written
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	// After "BZh" and the block size comes the magic of the first block, or
+	// of the end of the stream when nothing was compressed
+	magicBzip2Block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	magicBzip2End   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// How much of the file sniffCompression needs to see
+const sniffLength = 10
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	default:
+		return "none"
+	}
+}
+
+// ParseCompression accepts the compressions a file can be saved with
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "none", "off":
+		return NoCompression, nil
+	case "gzip", "gz":
+		return Gzip, nil
+	case "bzip2", "bz2":
+		return Bzip2, fmt.Errorf("bzip2 files can only be read, save them as gzip or uncompressed")
+	}
+	return NoCompression, fmt.Errorf("unknown compression %q, expected gzip or none", name)
+}
+
+// sniffCompression looks for the magic bytes of a compressed file. "BZh" is
+// also how plain text can start, so bzip2 needs the rest of its header too.
+func sniffCompression(head []byte) Compression {
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		return Gzip
+	case isBzip2(head):
+		return Bzip2
+	}
+	return NoCompression
+}
+
+// isBzip2 checks for "BZh", a block size from 1 to 9 and the magic of a block
+// or the end of the stream
+func isBzip2(head []byte) bool {
+	if len(head) < sniffLength || !bytes.HasPrefix(head, magicBzip2) {
+		return false
+	}
+	if level := head[len(magicBzip2)]; level < '1' || level > '9' {
+		return false
+	}
+	block := head[len(magicBzip2)+1 : sniffLength]
+	return bytes.Equal(block, magicBzip2Block) || bytes.Equal(block, magicBzip2End)
+}
+
+// compressionFor picks the compression of a new file from its extension
+func compressionFor(name string) Compression {
+	if strings.EqualFold(filepath.Ext(name), ".gz") {
+		return Gzip
+	}
+	return NoCompression
+}
+
+// SetCompression changes how the file is compressed when it is saved
+func (o *File) SetCompression(c Compression) error {
+	if o.ReadOnly {
+		return ErrReadOnly
+	}
+	if c == Bzip2 {
+		return fmt.Errorf("bzip2 files can only be read, save them as gzip or uncompressed")
+	}
+	o.Compression = c
+	o.changed = true
+	return nil
+}
+
+// decompress returns a reader for the uncompressed contents of the file
+// read from br
+func (o *File) decompress(br *bufio.Reader) (io.Reader, error) {
+	magic, _ := br.Peek(sniffLength)
+	o.Compression = sniffCompression(magic)
+	if len(magic) == 0 {
+		o.Compression = compressionFor(o.Name)
+	}
+	switch o.Compression {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return zr, nil
+	case Bzip2:
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// compress writes the buffer to w compressed the way the file is
+func (o *File) compress(w io.Writer) error {
+	switch o.Compression {
+	case Gzip:
+		zw := gzip.NewWriter(w)
+		if err := o.encode(zw); err != nil {
+			return err
+		}
+		return zw.Close()
+	case Bzip2:
+		return fmt.Errorf("%s is bzip2 compressed, which can't be written, choose gzip or no compression", o.Name)
+	}
+	return o.encode(w)
+}
@@ -0,0 +1,261 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Encrypted files are stored as
+//
+//	magic | iterations (uint32) | salt | nonce | AES-256-GCM sealed file
+//
+// The key comes from the passphrase with PBKDF2-HMAC-SHA256. Everything
+// before the nonce is authenticated along with the file. What is sealed is
+// the file as it would be saved otherwise, compressed and encoded.
+var magicCrypt = []byte("\x89ollie-x")
+
+const (
+	kdfIterations = 600000
+	saltSize      = 16
+	keySize       = 32
+	cryptHeader   = 8 + 4 + saltSize
+)
+
+var (
+	// ErrNeedPassphrase is returned when opening an encrypted file without
+	// setting a passphrase first
+	ErrNeedPassphrase = errors.New("file is encrypted, a passphrase is needed")
+
+	// ErrPassphrase is returned when an encrypted file can't be opened with
+	// the passphrase given, or it was damaged
+	ErrPassphrase = errors.New("wrong passphrase or damaged file")
+)
+
+// cryptState is the passphrase of an encrypted buffer along with the salt
+// and key derived from it. The key is only derived again for a new
+// passphrase since that is slow on purpose, every save uses a new nonce.
+type cryptState struct {
+	passphrase []byte
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// pbkdf2 derives a key of keyLen bytes from password as described in RFC
+// 8018 using HMAC-SHA256
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	size := prf.Size()
+	blocks := (keyLen + size - 1) / size
+
+	var counter [4]byte
+	dk := make([]byte, 0, blocks*size)
+	u := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-size:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// derive makes the key for salt from the passphrase
+func (c *cryptState) derive(salt []byte, iterations int) {
+	c.salt = salt
+	c.iterations = iterations
+	c.key = pbkdf2(c.passphrase, salt, iterations, keySize)
+}
+
+func (c *cryptState) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *cryptState) header() []byte {
+	hdr := append([]byte(nil), magicCrypt...)
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(c.iterations))
+	return append(hdr, c.salt...)
+}
+
+// seal encrypts plain into the container format
+func (c *cryptState) seal(plain []byte) ([]byte, error) {
+	if c.key == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		c.derive(salt, kdfIterations)
+	}
+	gcm, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	hdr := c.header()
+	out := append(hdr, nonce...)
+	return gcm.Seal(out, nonce, plain, hdr), nil
+}
+
+// open decrypts a container, deriving the key again if it was made with
+// another salt
+func (c *cryptState) open(data []byte) ([]byte, error) {
+	if len(data) < cryptHeader || !bytes.HasPrefix(data, magicCrypt) {
+		return nil, ErrPassphrase
+	}
+	iterations := int(binary.BigEndian.Uint32(data[len(magicCrypt):]))
+	salt := data[len(magicCrypt)+4 : cryptHeader]
+	if iterations < 1 || iterations > 100*kdfIterations {
+		return nil, ErrPassphrase
+	}
+	if c.key == nil || iterations != c.iterations || !bytes.Equal(salt, c.salt) {
+		c.derive(bytes.Clone(salt), iterations)
+	}
+
+	gcm, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	rest := data[cryptHeader:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], data[:cryptHeader])
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return plain, nil
+}
+
+// Encrypted reports whether the buffer is saved encrypted
+func (o *File) Encrypted() bool {
+	return o.crypt != nil
+}
+
+// SetPassphrase sets the passphrase the file is encrypted with. Set before
+// CreateFile it is also used to open the file. An empty passphrase saves the
+// file unencrypted.
+//
+// An encrypted buffer has no swap file since that would put the text on
+// disk unencrypted, any swap file it had is removed.
+func (o *File) SetPassphrase(passphrase string) error {
+	if o.ReadOnly && o.loaded {
+		return ErrReadOnly
+	}
+	if passphrase == "" {
+		o.crypt = nil
+		o.changed = true
+		return nil
+	}
+
+	o.crypt = &cryptState{passphrase: []byte(passphrase)}
+	o.changed = true
+	o.removeSwap()
+	return nil
+}
+
+// decrypt returns a reader for the contents of the encrypted file read from
+// br, or br itself if the file isn't encrypted
+func (o *File) decrypt(br *bufio.Reader) (*bufio.Reader, error) {
+	magic, _ := br.Peek(len(magicCrypt))
+	if !bytes.Equal(magic, magicCrypt) {
+		return br, nil
+	}
+	if o.crypt == nil {
+		return nil, ErrNeedPassphrase
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := o.crypt.open(data)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(bytes.NewReader(plain)), nil
+}
+
+// encrypt seals what fn writes and writes the container to w
+func (o *File) encrypt(w io.Writer, fn func(io.Writer) error) error {
+	var plain bytes.Buffer
+	if err := fn(&plain); err != nil {
+		return err
+	}
+	sealed, err := o.crypt.seal(plain.Bytes())
+	clear(plain.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(sealed)
+	return err
+}
+
+// isEncrypted reports whether the named file is in the encrypted format
+func isEncrypted(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(magicCrypt))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && bytes.Equal(magic, magicCrypt)
+}
+
+// describeEncryption is how the file is encrypted, for File.String
+func (o *File) describeEncryption() string {
+	if o.crypt == nil {
+		return "none"
+	}
+	return fmt.Sprintf("AES-256-GCM, key from PBKDF2-SHA256 with %d iterations", kdfIterations)
+}
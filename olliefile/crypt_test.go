@@ -0,0 +1,122 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test vectors from RFC 7914
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, 64))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "secret.txt")
+	f := NewFile(name)
+	f.Swap = true
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPassphrase("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	f.AppendLine("the launch codes")
+	if _, err := os.Stat(SwapName(name)); err == nil {
+		t.Error("encrypted buffer has a swap file")
+	}
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, magicCrypt) || bytes.Contains(data, []byte("launch")) {
+		t.Fatalf("file is not encrypted: %q", data)
+	}
+
+	g := NewFile(name)
+	if err := g.CreateFile(); !errors.Is(err, ErrNeedPassphrase) {
+		t.Errorf("CreateFile() without a passphrase = %v, want ErrNeedPassphrase", err)
+	}
+	g.SetPassphrase("hunter3")
+	if err := g.CreateFile(); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("CreateFile() with the wrong passphrase = %v, want ErrPassphrase", err)
+	}
+	g.SetPassphrase("hunter2")
+	if err := g.CreateFile(); err != nil {
+		t.Fatalf("CreateFile() = %v", err)
+	}
+	if g.Lines.Len() != 1 || g.Lines.Line(0) != "the launch codes" {
+		t.Errorf("decrypted %q", g.Lines.Slice(0, g.Lines.Len()))
+	}
+
+	// Reading it into another buffer needs the same passphrase
+	tests := []struct {
+		passphrase string
+		want       string
+	}{
+		{"", "set a passphrase"},
+		{"hunter3", "another passphrase"},
+		{"hunter2", ""},
+	}
+	for _, tt := range tests {
+		other := NewFile("other.txt")
+		if tt.passphrase != "" {
+			other.SetPassphrase(tt.passphrase)
+		}
+		_, err := other.InsertFile(0, name)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("InsertFile() with passphrase %q = %v, want %q", tt.passphrase, err, tt.want)
+		}
+	}
+
+	// Tampering with any byte is noticed
+	data[len(data)-1] ^= 1
+	os.WriteFile(name, data, 0644)
+	h := NewFile(name)
+	h.SetPassphrase("hunter2")
+	if err := h.CreateFile(); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("CreateFile() of a damaged file = %v, want ErrPassphrase", err)
+	}
+}
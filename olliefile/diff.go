@@ -0,0 +1,40 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+// DiffRange compares two buffers by skipping the lines they have in common
+// at the start and at the end. What is left over is the block that differs:
+// lines [from, toA) of a were replaced by lines [from, toB) of b. When the
+// buffers are the same from == toA == toB.
+func DiffRange(a, b Buffer) (from, toA, toB int) {
+	n := min(a.Len(), b.Len())
+	for from < n && a.Line(from) == b.Line(from) {
+		from += 1
+	}
+
+	toA, toB = a.Len(), b.Len()
+	for toA > from && toB > from && a.Line(toA-1) == b.Line(toB-1) {
+		toA -= 1
+		toB -= 1
+	}
+	return from, toA, toB
+}
@@ -0,0 +1,181 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrModified is returned when saving would overwrite changes made to the file
+// on disk by something else since we read it
+var ErrModified = errors.New("file changed on disk since it was read")
+
+// ErrUnsavedChanges is returned when replacing a buffer that can't be undone
+// would throw away changes that haven't been saved
+var ErrUnsavedChanges = errors.New("buffer has unsaved changes that can't be undone after this")
+
+// diskState is what the file looked like on disk when we last read or saved
+// it, used to notice when something else changes it underneath us.
+type diskState struct {
+	name  string // set once the file has been read or saved
+	size  int64
+	mtime time.Time
+	hash  [sha256.Size]byte
+	index *lineIndex // the hash of a mapped file is known once it is indexed
+}
+
+// record remembers the state of the file just read or written. sum is the
+// hash of the bytes that went through.
+func (d *diskState) record(name string, sum []byte) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	d.name = name
+	d.size = fi.Size()
+	d.mtime = fi.ModTime()
+	d.index = nil
+	copy(d.hash[:], sum)
+	return nil
+}
+
+// ChangedOnDisk reports whether the file was changed by something else since
+// we last read or saved it. The size and modification time are checked first,
+// the contents are only hashed when the modification time alone changed.
+// A file that was deleted doesn't count as changed since saving can't
+// clobber anything.
+func (o *File) ChangedOnDisk() (bool, error) {
+	if o.disk.name == "" || o.disk.name != o.Name {
+		return false, nil
+	}
+
+	fi, err := os.Stat(o.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() != o.disk.size {
+		return true, nil
+	}
+	if fi.ModTime().Equal(o.disk.mtime) {
+		return false, nil
+	}
+
+	sum, err := hashFile(o.Name)
+	if err != nil {
+		return false, err
+	}
+	if o.disk.index != nil {
+		_, _, o.disk.hash = o.disk.index.finish()
+		o.disk.index = nil
+	}
+	if sum != o.disk.hash {
+		return true, nil
+	}
+	// Only touched, no need to hash it again next time
+	o.disk.mtime = fi.ModTime()
+	return false, nil
+}
+
+func hashFile(name string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(name)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// Reload reads the file from disk again, replacing the buffer, and returns
+// what the buffer held before so the caller can show what changed. The
+// reload is a single change that can be undone, unless the file is binary
+// or mapped. Then unsaved changes are only thrown away when forced.
+func (o *File) Reload(force bool) (Buffer, error) {
+	old, fresh, err := o.replaceFrom(o.Name, force)
+	if err != nil {
+		return nil, err
+	}
+	o.disk = fresh.disk
+	o.markSaved()
+	o.loaded = true
+	o.removeSwap()
+	return old, nil
+}
+
+// replaceFrom replaces the buffer and the way it is saved with the contents
+// of the named file. It returns what the buffer held before and the file
+// that was read. When that can't be undone it returns ErrUnsavedChanges
+// rather than lose changes that weren't saved, unless forced.
+func (o *File) replaceFrom(name string, force bool) (Buffer, *File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fresh := &File{Name: name, FileHandle: f, MaxLineLength: o.MaxLineLength, MapThreshold: o.MapThreshold, crypt: o.crypt}
+	if err := fresh.readFile(); err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", name, err)
+	}
+
+	old := o.Lines.Snapshot()
+	_, mapped := fresh.Lines.(*mappedBuffer)
+	if o.Hex || fresh.Hex || mapped {
+		// Changes to the rows of a hex dump can't be undone and a mapped file
+		// is too big to copy into the undo history, start over
+		if o.Modified() && !force {
+			return nil, nil, ErrUnsavedChanges
+		}
+		o.Lines = fresh.Lines
+		o.history = journal{}
+		// With no history to tell, the buffer differs from the file on disk
+		// until a reload marks it saved
+		o.changed = true
+	} else {
+		o.lineEnds = nil
+		o.replaceLines(0, o.Lines.Len(), fresh.Lines.Slice(0, fresh.Lines.Len()))
+	}
+	o.Hex = fresh.Hex
+	o.raw = fresh.raw
+	o.Encoding = fresh.Encoding
+	o.BOM = fresh.BOM
+	o.Compression = fresh.Compression
+	o.EOL = fresh.EOL
+	o.MixedEOL = fresh.MixedEOL
+	o.NoFinalNewline = fresh.NoFinalNewline
+	o.lineEnds = fresh.lineEnds
+	o.stats = fresh.stats
+	o.counting = fresh.counting
+	return old, fresh, nil
+}
@@ -0,0 +1,331 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding of a file on disk. Buffers always hold
+// UTF-8, files are decoded when read and encoded again when saved.
+type Encoding int
+
+const (
+	UTF8 Encoding = iota
+	UTF16LE
+	UTF16BE
+	Latin1
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case UTF16LE:
+		return "UTF-16LE"
+	case UTF16BE:
+		return "UTF-16BE"
+	case Latin1:
+		return "Latin-1"
+	default:
+		return "UTF-8"
+	}
+}
+
+// ParseEncoding accepts utf-8, utf-16le, utf-16be and latin-1 in any case.
+// A "-bom" suffix asks for a byte order mark, which Latin-1 doesn't have.
+func ParseEncoding(name string) (Encoding, bool, error) {
+	name, bom := strings.CutSuffix(strings.ToLower(name), "-bom")
+	switch name {
+	case "utf-8", "utf8":
+		return UTF8, bom, nil
+	case "utf-16le", "utf16le":
+		return UTF16LE, bom, nil
+	case "utf-16be", "utf16be":
+		return UTF16BE, bom, nil
+	case "latin-1", "latin1", "iso-8859-1":
+		if !bom {
+			return Latin1, false, nil
+		}
+	}
+	return UTF8, false, fmt.Errorf("unknown encoding %q, expected utf-8, utf-16le, utf-16be or latin-1 with an optional -bom", name)
+}
+
+func (o *File) describeEncoding() string {
+	if o.Hex {
+		return fmt.Sprintf("binary, %d bytes shown in hex", len(o.raw))
+	}
+	if o.BOM {
+		return o.Encoding.String() + " with BOM"
+	}
+	return o.Encoding.String()
+}
+
+// SetEncoding changes the encoding the file is saved in. Converting to
+// Latin-1 fails if a line has characters it can't represent.
+func (o *File) SetEncoding(e Encoding, bom bool) error {
+	if o.ReadOnly {
+		return ErrReadOnly
+	}
+	if e == Latin1 {
+		var err error
+		o.Lines.Range(0, o.Lines.Len(), func(i int, line string) bool {
+			_, err = encodeLine(nil, Latin1, line, i)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		bom = false
+	}
+	o.Encoding = e
+	o.BOM = bom
+	o.changed = true
+	return nil
+}
+
+// sniffEncoding looks for a byte order mark at the start of head, or failing
+// that the NUL bytes that ASCII text in UTF-16 is full of. Latin-1 can only be
+// told apart from UTF-8 once the whole file has been read, see readFile.
+func sniffEncoding(head []byte) (Encoding, bool) {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return UTF8, true
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return UTF16LE, true
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return UTF16BE, true
+	}
+
+	// Count NUL bytes in the even and odd positions. Mostly ASCII UTF-16
+	// has one in every pair, on the odd side for little endian.
+	var even, odd int
+	for i := 0; i+1 < len(head); i += 2 {
+		if head[i] == 0 {
+			even += 1
+		}
+		if head[i+1] == 0 {
+			odd += 1
+		}
+	}
+	pairs := len(head) / 2
+	switch {
+	case pairs > 0 && odd > pairs*3/4 && even == 0:
+		return UTF16LE, false
+	case pairs > 0 && even > pairs*3/4 && odd == 0:
+		return UTF16BE, false
+	}
+	return UTF8, false
+}
+
+// decodeReader skips the byte order mark, if any, and returns a reader
+// decoding the rest of br from enc to UTF-8
+func decodeReader(br *bufio.Reader, enc Encoding, bom bool) io.Reader {
+	if bom {
+		switch enc {
+		case UTF8:
+			br.Discard(len(bomUTF8))
+		case UTF16LE, UTF16BE:
+			br.Discard(len(bomUTF16LE))
+		}
+	}
+
+	switch enc {
+	case UTF16LE:
+		return newUTF16Reader(br, binary.LittleEndian)
+	case UTF16BE:
+		return newUTF16Reader(br, binary.BigEndian)
+	}
+	return br
+}
+
+// latin1ToUTF8 converts a line read as raw bytes from a Latin-1 file
+func latin1ToUTF8(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
+
+// utf16Reader decodes UTF-16 to UTF-8. Unpaired surrogates and a trailing odd
+// byte are replaced with U+FFFD.
+type utf16Reader struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	out     []byte // decoded and waiting to be read
+	pending rune   // unit read ahead while looking for a low surrogate, or -1
+	err     error
+}
+
+func newUTF16Reader(r *bufio.Reader, order binary.ByteOrder) *utf16Reader {
+	return &utf16Reader{r: r, order: order, pending: -1}
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		u.fill()
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+func (u *utf16Reader) unit() (rune, error) {
+	if u.pending >= 0 {
+		r := u.pending
+		u.pending = -1
+		return r, nil
+	}
+	var b [2]byte
+	n, err := io.ReadFull(u.r, b[:])
+	if n == 1 {
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rune(u.order.Uint16(b[:])), nil
+}
+
+func (u *utf16Reader) fill() {
+	u.out = u.out[:0]
+	for len(u.out) < 4096 {
+		r, err := u.unit()
+		if err != nil {
+			u.err = err
+			return
+		}
+		if utf16.IsSurrogate(r) {
+			r2, err := u.unit()
+			if err == nil {
+				if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+					r = dec
+				} else {
+					r = utf8.RuneError
+					u.pending = r2
+				}
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		u.out = utf8.AppendRune(u.out, r)
+	}
+}
+
+// encodeLine appends s encoded as e to buf. i is the index of the line, used
+// to say which one couldn't be encoded.
+func encodeLine(buf []byte, e Encoding, s string, i int) ([]byte, error) {
+	switch e {
+	case UTF16LE, UTF16BE:
+		var order binary.AppendByteOrder = binary.LittleEndian
+		if e == UTF16BE {
+			order = binary.BigEndian
+		}
+		for _, u := range utf16.Encode([]rune(s)) {
+			buf = order.AppendUint16(buf, u)
+		}
+	case Latin1:
+		for _, r := range s {
+			if r > 0xff {
+				return buf, fmt.Errorf("line %d has %q which can't be encoded as Latin-1", i+1, r)
+			}
+			buf = append(buf, byte(r))
+		}
+	default:
+		buf = append(buf, s...)
+	}
+	return buf, nil
+}
+
+// encode writes the buffer to w the way it is saved on disk, in the file's
+// encoding with its line endings
+func (o *File) encode(w io.Writer) error {
+	if o.Hex {
+		_, err := w.Write(o.raw)
+		return err
+	}
+
+	var buf []byte
+	if o.BOM {
+		switch o.Encoding {
+		case UTF8:
+			buf = append(buf, bomUTF8...)
+		case UTF16LE:
+			buf = append(buf, bomUTF16LE...)
+		case UTF16BE:
+			buf = append(buf, bomUTF16BE...)
+		}
+	}
+
+	if m, ok := o.Lines.(*mappedBuffer); ok && o.streamable(m) {
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		return m.writeTo(w, lineEndings[o.EOL], !o.NoFinalNewline)
+	}
+
+	var err error
+	last := o.Lines.Len() - 1
+	o.Lines.Range(0, o.Lines.Len(), func(i int, s string) bool {
+		if i < last || !o.NoFinalNewline {
+			s += o.lineEnding(i)
+		}
+		buf, err = encodeLine(buf, o.Encoding, s, i)
+		if err == nil {
+			_, err = w.Write(buf)
+		}
+		buf = buf[:0]
+		return err == nil
+	})
+	if err == nil && len(buf) > 0 {
+		_, err = w.Write(buf)
+	}
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
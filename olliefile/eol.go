@@ -0,0 +1,124 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LineEnding is the terminator written after each line of a file
+type LineEnding int
+
+const (
+	LF LineEnding = iota
+	CRLF
+	CR
+)
+
+var lineEndings = map[LineEnding]string{
+	LF:   "\n",
+	CRLF: "\r\n",
+	CR:   "\r",
+}
+
+func (e LineEnding) String() string {
+	switch e {
+	case CRLF:
+		return "CRLF"
+	case CR:
+		return "CR"
+	default:
+		return "LF"
+	}
+}
+
+// ParseLineEnding accepts the names printed by LineEnding.String in any case
+func ParseLineEnding(name string) (LineEnding, error) {
+	switch strings.ToUpper(name) {
+	case "LF":
+		return LF, nil
+	case "CRLF":
+		return CRLF, nil
+	case "CR":
+		return CR, nil
+	}
+	return LF, fmt.Errorf("unknown line ending %q, expected lf, crlf or cr", name)
+}
+
+// setLineEndings picks the most common terminator in ends as the file's line
+// ending. The per line terminators are only kept around when the file mixes
+// more than one kind, otherwise every line simply uses o.EOL.
+func (o *File) setLineEndings(ends []LineEnding) {
+	counts := make(map[LineEnding]int)
+	for _, e := range ends {
+		counts[e] += 1
+	}
+
+	o.EOL = LF
+	for _, e := range []LineEnding{CRLF, CR} {
+		if counts[e] > counts[o.EOL] {
+			o.EOL = e
+		}
+	}
+
+	o.MixedEOL = len(counts) > 1
+	o.lineEnds = nil
+	if o.MixedEOL {
+		terminators := make([]string, len(ends))
+		for i, e := range ends {
+			terminators[i] = lineEndings[e]
+		}
+		o.lineEnds = NewRope(terminators...)
+	}
+}
+
+// SetLineEnding converts every line in the buffer to use e
+func (o *File) SetLineEnding(e LineEnding) error {
+	if o.ReadOnly {
+		return ErrReadOnly
+	}
+	o.EOL = e
+	o.MixedEOL = false
+	o.lineEnds = nil
+	o.changed = true
+	return nil
+}
+
+// lineEnding returns the terminator to write after line i (0 based)
+func (o *File) lineEnding(i int) string {
+	if o.lineEnds != nil && i < o.lineEnds.Len() {
+		return o.lineEnds.Line(i)
+	}
+	return lineEndings[o.EOL]
+}
+
+func (o *File) describeLineEndings() string {
+	s := o.EOL.String()
+	if o.MixedEOL {
+		s += " (mixed)"
+	}
+	if o.NoFinalNewline {
+		s += ", no newline at end of file"
+	}
+	return s
+}
@@ -0,0 +1,175 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrHexMode is returned when trying to edit the lines of a binary file, they
+// can only be changed a byte at a time with PatchBytes
+var ErrHexMode = errors.New("binary file is open in hex mode, only its bytes can be changed")
+
+// HexRowBytes is the number of bytes shown on each row of the hex view
+const HexRowBytes = 16
+
+// isBinary guesses whether a file is binary from its first bytes. Text in
+// UTF-16 is full of NUL bytes so it is ruled out first, anything else with a
+// NUL or where a large share of the bytes aren't valid UTF-8 is binary.
+// A few invalid bytes are fine, that is just Latin-1.
+func isBinary(head []byte, enc Encoding, bom bool) bool {
+	if bom || enc == UTF16LE || enc == UTF16BE {
+		return false
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	invalid := 0
+	for i := 0; i < len(head); {
+		r, size := utf8.DecodeRune(head[i:])
+		if r == utf8.RuneError && size == 1 {
+			invalid += 1
+		}
+		i += size
+	}
+	return invalid*10 > len(head)*3
+}
+
+// setHex puts the buffer into hex mode for the bytes in raw. The lines become
+// rows showing the offset, the bytes in hex and the printable ones as text.
+func (o *File) setHex(raw []byte) {
+	o.Hex = true
+	o.raw = raw
+	o.Lines = NewRope(hexRows(raw, 0, hexRowCount(len(raw)))...)
+	o.stats = hexStats(raw, o.Lines.Len())
+	o.history = journal{}
+}
+
+// hexStats counts the rows and bytes of a binary file, it has no words or
+// lines of text
+func hexStats(raw []byte, rows int) Stats {
+	return Stats{Lines: rows, Bytes: len(raw)}
+}
+
+func hexRowCount(size int) int {
+	return (size + HexRowBytes - 1) / HexRowBytes
+}
+
+// hexRows renders rows [from, to) of raw
+func hexRows(raw []byte, from, to int) []string {
+	rows := make([]string, 0, to-from)
+	for r := from; r < to; r++ {
+		start := r * HexRowBytes
+		row := raw[start:min(start+HexRowBytes, len(raw))]
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%08x ", start)
+		for i := 0; i < HexRowBytes; i++ {
+			if i == HexRowBytes/2 {
+				b.WriteByte(' ')
+			}
+			if i < len(row) {
+				fmt.Fprintf(&b, " %02x", row[i])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range row {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('|')
+		rows = append(rows, b.String())
+	}
+	return rows
+}
+
+// bytePatch is a change to the bytes of a file in hex mode. The bytes at
+// offset that were old become new, and the file goes from oldSize to
+// newSize bytes long.
+type bytePatch struct {
+	offset           int
+	old, new         []byte
+	oldSize, newSize int
+}
+
+func (p *bytePatch) reverse() *bytePatch {
+	return &bytePatch{offset: p.offset, old: p.new, new: p.old, oldSize: p.newSize, newSize: p.oldSize}
+}
+
+// PatchBytes overwrites the bytes at offset with data in a binary file open
+// in hex mode. Writing past the end makes the file longer, but offset itself
+// can be at most the current size. Like any other edit it can be undone.
+func (o *File) PatchBytes(offset int64, data []byte) error {
+	if !o.Hex {
+		return fmt.Errorf("%s is not open in hex mode", o.Name)
+	}
+	if o.ReadOnly {
+		return ErrReadOnly
+	}
+	if offset < 0 || offset > int64(len(o.raw)) {
+		return fmt.Errorf("offset %#x is outside the file, it is %#x bytes long", offset, len(o.raw))
+	}
+
+	end := int(offset) + len(data)
+	p := &bytePatch{
+		offset:  int(offset),
+		old:     bytes.Clone(o.raw[offset:min(end, len(o.raw))]),
+		new:     bytes.Clone(data),
+		oldSize: len(o.raw),
+		newSize: max(end, len(o.raw)),
+	}
+	c := change{at: p.offset / HexRowBytes, patch: p}
+	o.apply(c)
+	o.history.record(c)
+	return nil
+}
+
+// applyPatch changes the bytes and redraws the rows showing them, which
+// when the size changes are all the rows to the end
+func (o *File) applyPatch(p *bytePatch) {
+	oldRows := hexRowCount(len(o.raw))
+	end := p.offset + len(p.new)
+	if end > len(o.raw) {
+		o.raw = append(o.raw, make([]byte, end-len(o.raw))...)
+	}
+	copy(o.raw[p.offset:], p.new)
+	o.raw = o.raw[:p.newSize]
+
+	from := p.offset / HexRowBytes
+	to := hexRowCount(p.offset + max(len(p.old), len(p.new)))
+	o.Lines.Replace(from, min(to, oldRows), hexRows(o.raw, from, min(to, hexRowCount(len(o.raw))))...)
+	o.stats = hexStats(o.raw, o.Lines.Len())
+}
+
+// Size returns the size in bytes of a file open in hex mode
+func (o *File) Size() int {
+	return len(o.raw)
+}
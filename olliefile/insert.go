@@ -0,0 +1,88 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// InsertFile reads the file name and inserts its lines after line at, 0 puts
+// them before the first line. The file is decrypted, decompressed and
+// decoded the way it would be if it were opened, an encrypted one has to use
+// the same passphrase as the buffer. It returns the number of lines inserted.
+func (o *File) InsertFile(at int, name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+
+	src := &File{Name: name, FileHandle: f, MaxLineLength: o.MaxLineLength}
+	if o.crypt != nil {
+		src.crypt = &cryptState{passphrase: o.crypt.passphrase}
+	}
+	if err := src.readFile(); err != nil {
+		if errors.Is(err, ErrNeedPassphrase) {
+			return 0, fmt.Errorf("%s is encrypted, set a passphrase with x first", name)
+		}
+		if errors.Is(err, ErrPassphrase) {
+			return 0, fmt.Errorf("%s is encrypted with another passphrase or damaged", name)
+		}
+		return 0, fmt.Errorf("read %s: %w", name, err)
+	}
+	if src.Hex {
+		return 0, fmt.Errorf("%s is a binary file", name)
+	}
+
+	lines := src.Lines.Slice(0, src.Lines.Len())
+	return len(lines), o.insertLines(at, lines)
+}
+
+// InsertFrom inserts the lines read from r after line at, like InsertFile
+// does for a file. r is read as UTF-8 with any line endings.
+func (o *File) InsertFrom(at int, r io.Reader) (int, error) {
+	var lines []string
+	lr := newLineReader(r, o.MaxLineLength)
+	for {
+		line, _, _, err := lr.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		lines = append(lines, line)
+	}
+	return len(lines), o.insertLines(at, lines)
+}
+
+func (o *File) insertLines(at int, lines []string) error {
+	if at < 0 || at > o.Lines.Len() {
+		return fmt.Errorf("invalid line number %d", at)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return o.ReplaceLines(at, at, lines...)
+}
@@ -0,0 +1,162 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrReadOnly is returned when trying to change or save a read-only buffer
+var ErrReadOnly = errors.New("buffer is read-only")
+
+// LockError is returned by CreateFile when another ollie is already editing
+// the file
+type LockError struct {
+	Name string
+	Pid  int
+	Host string
+}
+
+func (e *LockError) Error() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("%s is being locked by another process", e.Name)
+	}
+	if e.Pid == os.Getpid() {
+		return fmt.Sprintf("%s is already open in this session", e.Name)
+	}
+	return fmt.Sprintf("%s is being edited by process %d on %s", e.Name, e.Pid, e.Host)
+}
+
+// An empty or unreadable lock file may be one another process has just
+// created and not written to yet, it is only stale once it is this old
+const lockWriteTime = 5 * time.Second
+
+// LockName returns the name of the lock file taken for the file name
+func LockName(name string) string {
+	dir, base := filepath.Split(name)
+	return filepath.Join(dir, "."+base+".ollie.lock")
+}
+
+// lock takes an advisory lock on the file by creating a lock file next to
+// it holding our pid and host name. Lock files left behind by a process on
+// this host that no longer exists are removed, and so are ones that still
+// say nothing after lockWriteTime. Locks from other hosts can't be checked
+// and are always respected.
+//
+// If the lock file can't be created at all, for example in a directory we
+// can't write to, the file is edited without a lock.
+func (o *File) lock() error {
+	name := LockName(o.Name)
+	host, _ := os.Hostname()
+
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d %s\n", os.Getpid(), host)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(name)
+				return err
+			}
+			o.lockName = name
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil
+		}
+
+		held := &LockError{Name: o.Name}
+		fi, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var stale bool
+		if _, err := fmt.Sscanf(string(data), "%d %s", &held.Pid, &held.Host); err != nil {
+			held.Pid, held.Host = 0, ""
+			stale = time.Since(fi.ModTime()) > lockWriteTime
+		} else {
+			stale = held.Host == host && !processAlive(held.Pid)
+		}
+		if !stale {
+			return held
+		}
+		if err := removeStaleLock(name, fi, data); err != nil {
+			return err
+		}
+	}
+}
+
+// removeStaleLock removes the lock file name if it is still the stale one
+// that was looked at, with the same inode, time and contents. Another
+// session may have removed that one and taken the lock since, so the lock
+// file is moved aside first and put back if it turns out to be different.
+func removeStaleLock(name string, stale os.FileInfo, data []byte) error {
+	aside := fmt.Sprintf("%s.%d.stale", name, os.Getpid())
+	if err := os.Rename(name, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !sameLock(aside, stale, data) {
+		// If yet another session took the lock in the meantime theirs stays
+		os.Link(aside, name)
+	}
+	return os.Remove(aside)
+}
+
+// unlock removes our lock file, if we hold one
+func (o *File) unlock() {
+	if o.lockName != "" {
+		os.Remove(o.lockName)
+		o.lockName = ""
+	}
+}
+
+// sameLock reports whether the lock file name is still the one described
+// by fi and data. Inodes are reused as soon as a file is removed, so the
+// time and contents are compared too.
+func sameLock(name string, fi os.FileInfo, data []byte) bool {
+	now, err := os.Stat(name)
+	if err != nil || !os.SameFile(now, fi) || !now.ModTime().Equal(fi.ModTime()) {
+		return false
+	}
+	got, err := os.ReadFile(name)
+	return err == nil && bytes.Equal(got, data)
+}
@@ -0,0 +1,480 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// DefaultMapThreshold is the size from which the editor maps files instead of
+// reading them, see File.MapThreshold
+const DefaultMapThreshold = 64 << 20
+
+// How much of the file the indexer looks at between waking up readers
+const indexChunk = 1 << 20
+
+// lineIndex finds where the lines of a memory mapped file start. It is built
+// in the background while the file is already being looked at, readers only
+// wait for the part of the index they need.
+type lineIndex struct {
+	data []byte
+	base int // bytes of the BOM before the first line
+	crlf bool
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	ends  []int // offset just past the terminator of each line
+	done  bool
+	stats Stats
+	sum   [sha256.Size]byte
+}
+
+// mapFile maps the open file f and starts indexing it. The mapping is
+// released once nothing refers to the index anymore.
+func mapFile(f *os.File, size int64, base int, crlf bool) (*lineIndex, error) {
+	data, unmap, err := mapRegion(f, size)
+	if err != nil {
+		return nil, err
+	}
+	x := &lineIndex{data: data, base: base, crlf: crlf}
+	x.cond = sync.NewCond(&x.mu)
+	runtime.SetFinalizer(x, func(*lineIndex) { unmap() })
+	go x.build()
+	return x, nil
+}
+
+func (x *lineIndex) build() {
+	h := sha256.New()
+	h.Write(x.data[:x.base])
+	start := x.base
+	for off := x.base; off < len(x.data); off += indexChunk {
+		chunk := x.data[off:min(off+indexChunk, len(x.data))]
+		h.Write(chunk)
+
+		var ends []int
+		var stats Stats
+		for i := 0; ; {
+			n := bytes.IndexByte(chunk[i:], '\n')
+			if n < 0 {
+				break
+			}
+			i += n + 1
+			ends = append(ends, off+i)
+			stats.add(x.trim(x.data[start : off+i]))
+			start = off + i
+		}
+
+		x.mu.Lock()
+		x.ends = append(x.ends, ends...)
+		x.stats.merge(stats)
+		x.mu.Unlock()
+		x.cond.Broadcast()
+	}
+
+	x.mu.Lock()
+	if start < len(x.data) {
+		x.ends = append(x.ends, len(x.data))
+		x.stats.add(x.trim(x.data[start:]))
+	}
+	copy(x.sum[:], h.Sum(nil))
+	x.done = true
+	x.mu.Unlock()
+	x.cond.Broadcast()
+}
+
+// wait blocks until at least n lines are indexed or the whole file is, and
+// returns how many lines are indexed
+func (x *lineIndex) wait(n int) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for len(x.ends) < n && !x.done {
+		x.cond.Wait()
+	}
+	return len(x.ends)
+}
+
+// known returns how many lines are indexed so far without waiting, and
+// whether that is all of them
+func (x *lineIndex) known() (int, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.ends), x.done
+}
+
+// finish waits for the whole index and returns the number of lines, the
+// counts for them and the hash of the file
+func (x *lineIndex) finish() (int, Stats, [sha256.Size]byte) {
+	x.wait(math.MaxInt)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.ends), x.stats, x.sum
+}
+
+// region returns the bytes of lines [from, to) including their terminators
+func (x *lineIndex) region(from, to int) []byte {
+	x.wait(to)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if to > len(x.ends) || from > to {
+		panic("olliefile: line index out of range")
+	}
+	start := x.base
+	if from > 0 {
+		start = x.ends[from-1]
+	}
+	end := start
+	if to > 0 {
+		end = x.ends[to-1]
+	}
+	return x.data[start:end]
+}
+
+// line returns line i without its terminator. The string is a copy so it
+// stays valid after the file is unmapped.
+func (x *lineIndex) line(i int) string {
+	return x.trim(x.region(i, i+1))
+}
+
+// trim copies a line out of the file without its terminator
+func (x *lineIndex) trim(b []byte) string {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	if x.crlf {
+		b = bytes.TrimSuffix(b, []byte("\r"))
+	}
+	return string(b)
+}
+
+// mappableEndings decides the line endings of a file that is about to be
+// mapped from its first line, files with old Mac CR endings aren't mapped.
+// Unlike a file that is read, a mapped file isn't checked for mixed endings.
+func mappableEndings(head []byte) (LineEnding, bool) {
+	i := bytes.IndexByte(head, '\n')
+	switch {
+	case i < 0:
+		return LF, bytes.IndexByte(head, '\r') < 0
+	case i > 0 && head[i-1] == '\r':
+		return CRLF, true
+	}
+	return LF, true
+}
+
+// mapLines makes the buffer a view of the open file instead of reading it.
+// The line and word counts and the hash of the file are filled in once the
+// index is finished.
+func (o *File) mapLines(size int64, eol LineEnding) error {
+	base := 0
+	if o.BOM {
+		base = len(bomUTF8)
+	}
+	x, err := mapFile(o.FileHandle, size, base, eol == CRLF)
+	if err != nil {
+		return err
+	}
+
+	o.Lines = &mappedBuffer{index: x}
+	o.EOL = eol
+	o.MixedEOL = false
+	o.lineEnds = nil
+	o.NoFinalNewline = len(x.data) > base && x.data[len(x.data)-1] != '\n'
+	o.stats = Stats{}
+	o.counting = x
+	o.history = journal{}
+	o.loaded = true
+	if err := o.disk.record(o.Name, nil); err != nil {
+		return err
+	}
+	o.disk.index = x
+	o.markSaved()
+	return nil
+}
+
+// settleCounts adds the counts for the lines of a mapped file to the stats,
+// waiting for the index if it isn't finished
+func (o *File) settleCounts() {
+	if o.counting == nil {
+		return
+	}
+	_, stats, _ := o.counting.finish()
+	o.stats.merge(stats)
+	o.counting = nil
+}
+
+// streamable reports whether the mapped file can be saved by copying the
+// lines that weren't changed as they are
+func (o *File) streamable(m *mappedBuffer) bool {
+	eol := LF
+	if m.index.crlf {
+		eol = CRLF
+	}
+	return o.Encoding == UTF8 && o.BOM == (m.index.base > 0) && o.EOL == eol && o.lineEnds == nil
+}
+
+// piece is a run of lines in a mappedBuffer, either lines [from, to) of the
+// mapped file or lines added while editing
+type piece struct {
+	from, to int
+	added    *node
+}
+
+func (p piece) len() int {
+	if p.added != nil {
+		return p.added.count
+	}
+	return p.to - p.from
+}
+
+// cut returns lines [from, to) of the piece
+func (p piece) cut(from, to int) piece {
+	if p.added != nil {
+		_, r := split(p.added, from)
+		l, _ := split(r, to-from)
+		return piece{added: l}
+	}
+	return piece{from: p.from + from, to: p.from + to}
+}
+
+// mappedBuffer is a Buffer over a memory mapped file. Lines are only copied
+// out of the file when they are looked at, edits are kept as a list of pieces
+// of the file and of new lines, so a huge file costs little more memory than
+// its line index.
+type mappedBuffer struct {
+	index    *lineIndex
+	pieces   []piece
+	resolved bool // pieces is set, before the first edit the buffer is the whole file
+}
+
+var _ Buffer = (*mappedBuffer)(nil)
+
+// resolve waits for the index so the buffer knows how many lines it has
+func (m *mappedBuffer) resolve() {
+	if m.resolved {
+		return
+	}
+	n, _, _ := m.index.finish()
+	if n > 0 {
+		m.pieces = []piece{{from: 0, to: n}}
+	}
+	m.resolved = true
+}
+
+// KnownLen returns how many lines of b there are without waiting, and whether
+// that is all of them. Only a mapped file still being indexed doesn't know
+// all its lines yet, Len waits for the index.
+func KnownLen(b Buffer) (int, bool) {
+	if m, ok := b.(*mappedBuffer); ok && !m.resolved {
+		return m.index.known()
+	}
+	return b.Len(), true
+}
+
+// HasLines reports whether b has at least n lines. A mapped file is only
+// waited for until its first n lines are indexed.
+func HasLines(b Buffer, n int) bool {
+	if m, ok := b.(*mappedBuffer); ok && !m.resolved {
+		return m.index.wait(n) >= n
+	}
+	return b.Len() >= n
+}
+
+func (m *mappedBuffer) Len() int {
+	m.resolve()
+	n := 0
+	for _, p := range m.pieces {
+		n += p.len()
+	}
+	return n
+}
+
+func (m *mappedBuffer) Line(i int) string {
+	line := ""
+	m.Range(i, i+1, func(_ int, s string) bool {
+		line = s
+		return false
+	})
+	return line
+}
+
+func (m *mappedBuffer) Slice(from, to int) []string {
+	lines := make([]string, 0, to-from)
+	m.Range(from, to, func(_ int, line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	return lines
+}
+
+func (m *mappedBuffer) Range(from, to int, fn func(i int, line string) bool) {
+	if from < 0 || to < from {
+		panic("olliefile: line range out of range")
+	}
+	// Until the first edit lines come straight from the index, which doesn't
+	// have to be finished for the lines asked for
+	if !m.resolved {
+		if m.index.wait(to) < to {
+			panic("olliefile: line range out of range")
+		}
+		for i := from; i < to; i++ {
+			if !fn(i, m.index.line(i)) {
+				return
+			}
+		}
+		return
+	}
+
+	if to > m.Len() {
+		panic("olliefile: line range out of range")
+	}
+	offset := 0
+	for _, p := range m.pieces {
+		n := p.len()
+		if offset+n > from && offset < to {
+			lo, hi := max(from-offset, 0), min(to-offset, n)
+			if p.added != nil {
+				if !walk(p.added, offset, offset+lo, offset+hi, fn) {
+					return
+				}
+			} else {
+				for i := lo; i < hi; i++ {
+					if !fn(offset+i, m.index.line(p.from+i)) {
+						return
+					}
+				}
+			}
+		}
+		offset += n
+	}
+}
+
+func (m *mappedBuffer) Insert(at int, lines ...string) {
+	m.Replace(at, at, lines...)
+}
+
+func (m *mappedBuffer) Delete(from, to int) {
+	m.Replace(from, to)
+}
+
+// Replace builds a new list of pieces, the old one may be shared with
+// snapshots
+func (m *mappedBuffer) Replace(from, to int, lines ...string) {
+	n := m.Len()
+	if from < 0 || to < from || to > n {
+		panic("olliefile: line range out of range")
+	}
+
+	pieces := make([]piece, 0, len(m.pieces)+2)
+	offset := 0
+	for _, p := range m.pieces {
+		l := p.len()
+		if offset < from {
+			pieces = appendPiece(pieces, p.cut(0, min(from-offset, l)))
+		}
+		offset += l
+	}
+	if len(lines) > 0 {
+		pieces = appendPiece(pieces, piece{added: build(append([]string(nil), lines...))})
+	}
+	offset = 0
+	for _, p := range m.pieces {
+		l := p.len()
+		if offset+l > to {
+			pieces = appendPiece(pieces, p.cut(max(to-offset, 0), l))
+		}
+		offset += l
+	}
+	m.pieces = pieces
+}
+
+// appendPiece adds p to pieces, merging it with the last piece when they
+// are next to each other
+func appendPiece(pieces []piece, p piece) []piece {
+	if p.len() == 0 {
+		return pieces
+	}
+	if len(pieces) > 0 {
+		last := &pieces[len(pieces)-1]
+		if last.added != nil && p.added != nil {
+			last.added = join(last.added, p.added)
+			return pieces
+		}
+		if last.added == nil && p.added == nil && last.to == p.from {
+			last.to = p.to
+			return pieces
+		}
+	}
+	return append(pieces, p)
+}
+
+func (m *mappedBuffer) Snapshot() Buffer {
+	c := *m
+	return &c
+}
+
+// writeTo writes the buffer with each line ending in eol, copying lines that
+// weren't changed straight from the mapped file. When last is false the
+// final line has no terminator.
+func (m *mappedBuffer) writeTo(w io.Writer, eol string, last bool) error {
+	m.resolve()
+	total, _, _ := m.index.finish()
+	var buf []byte
+	for i, p := range m.pieces {
+		final := i == len(m.pieces)-1
+		if p.added != nil {
+			var err error
+			walk(p.added, 0, 0, p.added.count, func(j int, line string) bool {
+				buf = append(buf[:0], line...)
+				if !final || j < p.added.count-1 || last {
+					buf = append(buf, eol...)
+				}
+				_, err = w.Write(buf)
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		region := m.index.region(p.from, p.to)
+		terminated := p.to < total || m.index.data[len(m.index.data)-1] == '\n'
+		if final && !last && terminated {
+			region = bytes.TrimSuffix(region, []byte("\n"))
+			if m.index.crlf {
+				region = bytes.TrimSuffix(region, []byte("\r"))
+			}
+		}
+		if _, err := w.Write(region); err != nil {
+			return err
+		}
+		if !terminated && (!final || last) {
+			if _, err := io.WriteString(w, eol); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
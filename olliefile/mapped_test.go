@@ -0,0 +1,151 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mapTestFile writes data to a file and opens it mapped
+func mapTestFile(t *testing.T, data string) *File {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "big.txt")
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	f.MapThreshold = 1
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Lines.(*mappedBuffer); !ok {
+		t.Fatalf("%s was not mapped", name)
+	}
+	return f
+}
+
+func TestMappedBufferMatchesSlice(t *testing.T) {
+	var want []string
+	for i := 0; i < 5000; i++ {
+		want = append(want, fmt.Sprintf("line %d", i))
+	}
+	f := mapTestFile(t, strings.Join(want, "\n")+"\n")
+	buf := f.Lines
+	if buf.Line(10) != "line 10" {
+		t.Fatalf("Line(10) = %q before any edit", buf.Line(10))
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	var snap Buffer
+	var snapWant []string
+	for step := 0; step < 500; step++ {
+		from := rng.Intn(len(want) + 1)
+		to := from + rng.Intn(min(len(want)-from, 50)+1)
+		lines := make([]string, rng.Intn(5))
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%d.%d", step, i)
+		}
+		buf.Replace(from, to, lines...)
+		want = slices.Replace(want, from, to, lines...)
+		if step == 100 {
+			snap, snapWant = buf.Snapshot(), slices.Clone(want)
+		}
+		if buf.Len() != len(want) {
+			t.Fatalf("step %d: Len() = %d, want %d", step, buf.Len(), len(want))
+		}
+	}
+
+	if got := buf.Slice(0, buf.Len()); !slices.Equal(got, want) {
+		t.Fatal("mapped buffer differs from slice")
+	}
+	if got := snap.Slice(0, snap.Len()); !slices.Equal(got, snapWant) {
+		t.Fatal("snapshot changed after later edits")
+	}
+}
+
+func TestMappedFileSave(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"lf", "one\ntwo\nthree\n", "one\n2\nthree\nfour\n"},
+		{"crlf", "one\r\ntwo\r\nthree\r\n", "one\r\n2\r\nthree\r\nfour\r\n"},
+		{"no final newline", "one\ntwo\nthree", "one\n2\nthree\nfour"},
+		{"bom", "\ufeffone\ntwo\nthree\n", "\ufeffone\n2\nthree\nfour\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := mapTestFile(t, tt.in)
+			if f.Lines.Line(0) != "one" {
+				t.Fatalf("Line(0) = %q, want %q", f.Lines.Line(0), "one")
+			}
+			f.UpdateLine("2", "2")
+			f.AppendLine("four")
+			if got, want := f.Stats(), statsOf(f.Lines); got.Lines != 4 || got.String() != want.String() {
+				t.Errorf("Stats() = %+v, want %+v", got, want)
+			}
+			if _, err := f.WriteFile(); err != nil {
+				t.Fatalf("WriteFile() = %v", err)
+			}
+			data, _ := os.ReadFile(f.Name)
+			if string(data) != tt.want {
+				t.Errorf("saved %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownLen(t *testing.T) {
+	// An index the builder is still working on, with two of its lines done
+	x := &lineIndex{data: []byte("one\ntwo\nthree\n"), ends: []int{4, 8}}
+	x.cond = sync.NewCond(&x.mu)
+	buf := &mappedBuffer{index: x}
+
+	if n, done := KnownLen(buf); n != 2 || done {
+		t.Errorf("KnownLen() while indexing = %d, %v, want 2, false", n, done)
+	}
+	if !HasLines(buf, 2) || buf.Line(1) != "two" {
+		t.Error("the lines indexed so far can't be used")
+	}
+
+	x.mu.Lock()
+	x.ends, x.done = append(x.ends, 14), true
+	x.mu.Unlock()
+	x.cond.Broadcast()
+	if n, done := KnownLen(buf); n != 3 || !done {
+		t.Errorf("KnownLen() once indexed = %d, %v, want 3, true", n, done)
+	}
+	if HasLines(buf, 4) {
+		t.Error("HasLines(4) on a file of 3 lines")
+	}
+	if n, done := KnownLen(NewRope("a", "b")); n != 2 || !done {
+		t.Errorf("KnownLen() of a rope = %d, %v", n, done)
+	}
+}
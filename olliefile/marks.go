@@ -0,0 +1,85 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"slices"
+	"sort"
+)
+
+// marks are the lines picked out by a global command that are still waiting
+// for it to run its commands on them. They are kept in order and follow
+// their lines as lines are added and removed around them, a marked line
+// that is deleted is no longer marked.
+//
+// Global commands work through their marks from the first, mostly changing
+// lines at or near the one they are on, so every mark after a change moves
+// by one shared delta and only the few before it are fixed up one by one.
+type marks struct {
+	lines []int // each line is lines[i] + delta
+	delta int
+}
+
+// SetMarks marks the lines, which are numbered from 0 and in order
+func (o *File) SetMarks(lines []int) {
+	o.marks = marks{lines: slices.Clone(lines)}
+}
+
+// NextMark unmarks the first marked line and returns it
+func (o *File) NextMark() (int, bool) {
+	m := &o.marks
+	if len(m.lines) == 0 {
+		return 0, false
+	}
+	line := m.lines[0] + m.delta
+	m.lines = m.lines[1:]
+	return line, true
+}
+
+// ClearMarks unmarks every line
+func (o *File) ClearMarks() {
+	o.marks = marks{}
+}
+
+// adjust moves the marks for removed lines at at being replaced by added
+// lines. The lines replaced one for one keep their marks.
+func (m *marks) adjust(at, removed, added int) {
+	if len(m.lines) == 0 {
+		return
+	}
+	find := func(line int) int {
+		return sort.Search(len(m.lines), func(i int) bool { return m.lines[i]+m.delta >= line })
+	}
+	kept := find(at + min(removed, added))
+	gone := find(at + removed)
+
+	if kept == 0 {
+		m.lines = m.lines[gone:]
+	} else {
+		m.lines = slices.Delete(m.lines, kept, gone)
+	}
+	diff := added - removed
+	m.delta += diff
+	for i := 0; i < kept; i++ {
+		m.lines[i] -= diff
+	}
+}
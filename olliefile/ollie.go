@@ -23,120 +23,445 @@ package olliefile
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
-	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type File struct {
 	Name       string
 	FileHandle *os.File
-	Lines      []string
-	WordCount  int
-	LineCount  int
-	Saved      bool
+	Lines      Buffer
 	LastSaved  time.Time
+
+	// Line endings are detected when the file is read and written back the
+	// same way. EOL is the most common terminator and is used for new lines.
+	EOL            LineEnding
+	MixedEOL       bool
+	NoFinalNewline bool
+	lineEnds       Buffer // per line terminators, only set for mixed files
+
+	// The encoding the file is saved in, the buffer itself is always UTF-8
+	Encoding Encoding
+	BOM      bool
+
+	// Compressed files are uncompressed when they are read and compressed
+	// again when they are saved, see compress.go
+	Compression Compression
+
+	// The passphrase of an encrypted file, see crypt.go
+	crypt *cryptState
+
+	// Binary files are opened in hex mode, where the lines are a read-only
+	// hex dump of raw and the bytes are changed with PatchBytes
+	Hex bool
+	raw []byte
+
+	// MaxLineLength is the longest line in bytes we are willing to load,
+	// 0 means there is no limit
+	MaxLineLength int
+
+	// Files of at least MapThreshold bytes are memory mapped instead of read
+	// and their lines indexed in the background, see mapped.go. 0 turns it
+	// off.
+	MapThreshold int64
+	counting     *lineIndex // stats is missing the lines of the mapped file
+
+	stats Stats
+
+	history journal
+	changed bool // changed since the save in a way undo doesn't know about
+
+	// Lines marked by a global command, see marks.go
+	marks marks
+
+	// Swap turns on the swap file used to recover unsaved changes, see
+	// swap.go
+	Swap     bool
+	swap     *os.File
+	swapName string
+	swapErr  error
+	loaded   bool // the buffer started out as the file on disk
+
+	disk diskState
+
+	// Backup is the kind of backup made of the file on disk before it is
+	// overwritten, see backup.go. Timestamped backups go in BackupDir, or
+	// next to the file when it is empty, and only the newest BackupKeep
+	// backups are kept unless it is 0.
+	Backup     BackupMode
+	BackupDir  string
+	BackupKeep int
+
+	// Lock takes an advisory lock on the file in CreateFile so two sessions
+	// don't edit it at the same time. A read-only buffer can't be changed or
+	// saved and doesn't take the lock.
+	Lock     bool
+	ReadOnly bool
+	lockName string
+}
+
+// NewFile returns an empty buffer for the named file. Call CreateFile to
+// load the file from disk.
+func NewFile(name string) *File {
+	return &File{Name: name, Lines: NewRope()}
+}
+
+// Modified reports whether the buffer has changes that aren't saved. Undoing
+// every change since the last save makes it unmodified again.
+func (o *File) Modified() bool {
+	return o.changed || o.history.saved != len(o.history.undo)
+}
+
+// markSaved records that the buffer is the same as the file on disk
+func (o *File) markSaved() {
+	o.history.markSaved()
+	o.changed = false
+	o.LastSaved = time.Now()
+}
+
+// Stats returns the counts for the buffer. For a mapped file that is still
+// being indexed it waits for the index to finish.
+func (o *File) Stats() Stats {
+	o.settleCounts()
+	return o.stats
 }
 
 func (o *File) String() string {
-	return fmt.Sprintf("File: %s\nLine Count: %d\nWord Count: %d\nLast Saved: %s",
-		o.Name, o.LineCount, o.WordCount, o.LastSaved.Format("2006-01-02 15:04:05"))
+	swap := "off"
+	switch {
+	case o.crypt != nil:
+		swap = "off (encrypted)"
+	case o.swapErr != nil:
+		swap = fmt.Sprintf("off (%v)", o.swapErr)
+	case o.swap != nil:
+		swap = o.swapName
+	case o.Swap:
+		swap = "on"
+	}
+	modified := "no"
+	if o.Modified() {
+		modified = "yes"
+	}
+	saved := "never"
+	if !o.LastSaved.IsZero() {
+		saved = o.LastSaved.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("File: %s\nModified: %s\n%s\nEncoding: %s\nLine Endings: %s\nCompression: %s\nEncryption: %s\nSwap File: %s\nLast Saved: %s",
+		o.Name, modified, o.Stats(), o.describeEncoding(), o.describeLineEndings(), o.Compression,
+		o.describeEncryption(), swap, saved)
 }
 
+// WriteFile saves the buffer to o.Name. The save is atomic, see writeAtomic,
+// so a failed write never destroys the previous version of the file.
+//
+// If the file was changed on disk since it was read, ErrModified is returned
+// and nothing is written, use ForceWriteFile to overwrite it anyway.
 func (o *File) WriteFile() (int, error) {
-	if o.FileHandle == nil {
-		return 0, fmt.Errorf("Error: File handle null")
+	changed, err := o.ChangedOnDisk()
+	if err != nil {
+		return 0, err
+	}
+	if changed {
+		return 0, fmt.Errorf("%s: %w", o.Name, ErrModified)
 	}
+	return o.ForceWriteFile()
+}
 
-	err := o.FileHandle.Truncate(0)
+// ForceWriteFile saves the buffer like WriteFile but without checking whether
+// the file changed on disk
+func (o *File) ForceWriteFile() (int, error) {
+	if o.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if o.Name == "" {
+		return 0, fmt.Errorf("no file name specified")
+	}
+
+	target, err := resolveTarget(o.Name)
 	if err != nil {
 		return 0, err
 	}
+	if err := o.makeBackup(target); err != nil {
+		return 0, err
+	}
 
-	_, err = o.FileHandle.Seek(0, 0)
+	h := sha256.New()
+	target, bytes, err := writeAtomic(o.Name, func(w io.Writer) (int, error) {
+		cw := &countingWriter{w: io.MultiWriter(w, h)}
+		err := o.save(cw)
+		return cw.n, err
+	})
 	if err != nil {
 		return 0, err
 	}
+	o.disk.record(o.Name, h.Sum(nil))
 
-	bytes := 0
-	for _, s := range o.Lines {
-		bw, err := fmt.Fprintln(o.FileHandle, s)
-		bytes += bw
+	// The old handle still points at the file we just replaced
+	if o.FileHandle != nil {
+		o.FileHandle.Close()
+		o.FileHandle, err = os.OpenFile(target, os.O_RDWR, 0)
 		if err != nil {
 			return bytes, err
 		}
 	}
-	o.FileHandle.Sync()
-	o.Saved = true
-	o.LastSaved = time.Now()
+	o.markSaved()
+	o.loaded = true
+	o.swapErr = nil
+	o.removeSwap()
 	return bytes, nil
 }
 
-func (o *File) UpdateLine(lineNumber string, str string) error {
-	line, err := strconv.ParseInt(lineNumber, 10, 32)
-	if err != nil {
-		return fmt.Errorf("param", err)
+// save writes the buffer to w the way it is stored on disk: encoded,
+// compressed and encrypted
+func (o *File) save(w io.Writer) error {
+	if o.crypt != nil {
+		return o.encrypt(w, o.compress)
 	}
+	return o.compress(w)
+}
 
-	if line < 1 || line > int64(len(o.Lines)) {
-		return fmt.Errorf("invalid line number")
+// ReplaceLines replaces lines [from, to) of the buffer with lines. Lines are
+// numbered from 0, so ReplaceLines(at, at, ...) inserts and ReplaceLines(from,
+// to) deletes. Lines replaced one for one keep their line endings.
+//
+// Every edit goes through here and is recorded so it can be undone.
+func (o *File) ReplaceLines(from, to int, lines ...string) error {
+	if o.ReadOnly {
+		return ErrReadOnly
+	}
+	if o.Hex {
+		return ErrHexMode
 	}
+	return o.replaceLines(from, to, lines)
+}
 
-	o.Lines[line-1] = str
+// replaceLines is ReplaceLines without the read-only check
+func (o *File) replaceLines(from, to int, lines []string) error {
+	if from < 0 || to < from || to > o.Lines.Len() {
+		return fmt.Errorf("invalid line range %d,%d", from+1, to)
+	}
 
-	err = o.FileHandle.Truncate(0)
-	if err != nil {
-		return err
+	c := change{at: from, old: o.Lines.Slice(from, to), new: lines}
+	if o.lineEnds != nil {
+		c.oldEnds = o.lineEnds.Slice(from, to)
+		c.newEnds = make([]string, len(lines))
+		for i := range c.newEnds {
+			if i < len(c.oldEnds) {
+				c.newEnds[i] = c.oldEnds[i]
+			} else {
+				c.newEnds[i] = lineEndings[o.EOL]
+			}
+		}
+	}
+
+	o.apply(c)
+	o.history.record(c)
+	return nil
+}
+
+// apply makes the edit described by c without recording it
+func (o *File) apply(c change) {
+	if c.patch != nil {
+		o.applyPatch(c.patch)
+		return
 	}
+	to := c.at + len(c.old)
+	if o.lineEnds != nil {
+		ends := c.newEnds
+		if len(ends) != len(c.new) {
+			ends = make([]string, len(c.new))
+			for i := range ends {
+				ends[i] = lineEndings[o.EOL]
+			}
+		}
+		o.lineEnds.Replace(c.at, to, ends...)
+	}
+	o.Lines.Replace(c.at, to, c.new...)
+	o.marks.adjust(c.at, len(c.old), len(c.new))
+	o.writeSwap(c)
 
-	_, err = o.FileHandle.Seek(0, 0)
+	o.settleCounts()
+	for _, s := range c.old {
+		o.stats.remove(s)
+	}
+	for _, s := range c.new {
+		o.stats.add(s)
+	}
+}
+
+// UpdateLine replaces a line in the buffer, lineNumber counts from 1
+func (o *File) UpdateLine(lineNumber string, str string) error {
+	line, err := strconv.ParseInt(lineNumber, 10, 32)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid line number %q", lineNumber)
 	}
 
-	_, err = o.WriteFile()
-	return err
+	if line < 1 || line > int64(o.Lines.Len()) {
+		return fmt.Errorf("invalid line number")
+	}
+	return o.ReplaceLines(int(line-1), int(line), str)
+}
+
+// AppendLine adds str as a new line at the end of the buffer
+func (o *File) AppendLine(str string) error {
+	n := o.Lines.Len()
+	return o.ReplaceLines(n, n, str)
+}
+
+// DeleteLastLine removes the last line from the buffer
+func (o *File) DeleteLastLine() error {
+	n := o.Lines.Len()
+	if n == 0 {
+		return fmt.Errorf("buffer is empty")
+	}
+	return o.ReplaceLines(n-1, n)
 }
 
+// CreateFile loads the file into the buffer, creating it if it doesn't exist
+// yet. With o.Lock set it returns a *LockError if another session is
+// already editing the file. A file we can't save is opened read-only.
 func (o *File) CreateFile() error {
 	if o.Name == "" {
-		return fmt.Errorf("ERROR: No file name speified")
+		return fmt.Errorf("no file name specified")
+	}
+	if !o.ReadOnly && !writable(o.Name) {
+		o.ReadOnly = true
+	}
+
+	if o.Lock && !o.ReadOnly {
+		err := o.lock()
+		if err != nil {
+			return err
+		}
 	}
 
 	doesExist, err := os.OpenFile(o.Name, os.O_RDONLY, 0)
+	created := errors.Is(err, os.ErrNotExist)
+	if created {
+		o.Compression = compressionFor(o.Name)
+	}
 	if err == nil {
 		o.FileHandle = doesExist
 		err := o.readFile()
 		if err != nil {
-			return fmt.Errorf("read file", err)
+			o.FileHandle = nil
+			o.unlock()
+			return fmt.Errorf("read %s: %w", o.Name, err)
 		}
+	} else if o.Lines == nil {
+		o.Lines = NewRope()
 	}
 
+	if o.ReadOnly {
+		return nil
+	}
 	f, err := os.OpenFile(o.Name, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
+		o.unlock()
 		return err
 	}
 	o.FileHandle = f
+	// The empty file just made is what is on disk, anything written to it
+	// before the first save is someone else's
+	if created {
+		empty := sha256.Sum256(nil)
+		o.disk.record(o.Name, empty[:])
+	}
 	return nil
 }
 
+// readFile loads the file into a fresh buffer
 func (o *File) readFile() error {
 	defer o.FileHandle.Close()
 
-	scanner := bufio.NewScanner(o.FileHandle)
-	for scanner.Scan() {
-		o.Lines = append(o.Lines, scanner.Text())
-		o.LineCount += 1
-		o.WordCount += len(strings.Split(" ", scanner.Text()))
+	var lines []string
+	var ends []LineEnding
+	o.NoFinalNewline = false
+	h := sha256.New()
+	disk := bufio.NewReader(io.TeeReader(o.FileHandle, h))
+	plain, err := o.decrypt(disk)
+	if err != nil {
+		return err
 	}
-
-	if err := scanner.Err(); err != nil {
+	src, err := o.decompress(plain)
+	if err != nil {
 		return err
 	}
-	o.Saved = true
-	o.LastSaved = time.Now()
+
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(4096)
+	o.Encoding, o.BOM = sniffEncoding(head)
+	o.Hex = false
+	o.raw = nil
+	o.counting = nil
+	if isBinary(head, o.Encoding, o.BOM) {
+		raw, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		o.Encoding, o.BOM = UTF8, false
+		o.setHex(raw)
+		o.loaded = true
+		o.markSaved()
+		io.Copy(io.Discard, disk)
+		return o.disk.record(o.Name, h.Sum(nil))
+	}
+	if o.MapThreshold > 0 && o.Encoding == UTF8 && o.Compression == NoCompression && plain == disk {
+		fi, err := o.FileHandle.Stat()
+		if err != nil {
+			return err
+		}
+		if eol, ok := mappableEndings(head); ok && fi.Size() >= o.MapThreshold {
+			return o.mapLines(fi.Size(), eol)
+		}
+	}
+
+	lr := newLineReader(decodeReader(br, o.Encoding, o.BOM), o.MaxLineLength)
+	valid := true
+	for {
+		line, end, ok, err := lr.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			ends = append(ends, end)
+		} else {
+			o.NoFinalNewline = true
+		}
+		lines = append(lines, line)
+		valid = valid && utf8.ValidString(line)
+	}
+
+	// Anything that isn't valid UTF-8 is taken to be Latin-1, where every
+	// byte is a character
+	if !valid && o.Encoding == UTF8 && !o.BOM {
+		o.Encoding = Latin1
+		for i := range lines {
+			lines[i] = latin1ToUTF8(lines[i])
+		}
+	}
+	o.Lines = NewRope(lines...)
+	o.stats = statsOf(o.Lines)
+	o.history = journal{}
+	o.loaded = true
+	// The hash is of the whole file on disk, even anything after the end of
+	// the compressed data
+	io.Copy(io.Discard, disk)
+	o.disk.record(o.Name, h.Sum(nil))
+	o.setLineEndings(ends)
+	if o.lineEnds != nil && o.NoFinalNewline {
+		o.lineEnds.Insert(o.lineEnds.Len(), lineEndings[o.EOL])
+	}
+	o.markSaved()
 
 	return nil
 }
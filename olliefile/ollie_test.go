@@ -0,0 +1,878 @@
+package olliefile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf16"
+)
+
+func TestWriteFileFollowsSymlinkAndKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real.txt")
+	link := filepath.Join(dir, "link.txt")
+	if err := os.WriteFile(real, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real.txt", link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	f := NewFile(link)
+	f.AppendLine("new")
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	fi, err := os.Lstat(link)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("%s is no longer a symlink", link)
+	}
+	data, err := os.ReadFile(real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new\n" {
+		t.Errorf("contents = %q, want %q", data, "new\n")
+	}
+	fi, err = os.Stat(real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("temporary file left behind: %d entries in %s", len(entries), dir)
+	}
+}
+
+func TestLineEndingsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		eol   LineEnding
+		mixed bool
+	}{
+		{"lf", "one\ntwo\n", LF, false},
+		{"crlf", "one\r\ntwo\r\n", CRLF, false},
+		{"cr", "one\rtwo\r", CR, false},
+		{"mixed", "one\r\ntwo\nthree\r\n", CRLF, true},
+		{"no final newline", "one\r\ntwo", CRLF, false},
+		{"empty last line", "one\n\n", LF, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "f.txt")
+			if err := os.WriteFile(name, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f := NewFile(name)
+			if err := f.CreateFile(); err != nil {
+				t.Fatalf("CreateFile() = %v", err)
+			}
+			if f.EOL != tt.eol || f.MixedEOL != tt.mixed {
+				t.Errorf("EOL = %v mixed %v, want %v mixed %v", f.EOL, f.MixedEOL, tt.eol, tt.mixed)
+			}
+			if _, err := f.WriteFile(); err != nil {
+				t.Fatalf("WriteFile() = %v", err)
+			}
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("round trip = %q, want %q", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestReadLongLines(t *testing.T) {
+	long := strings.Repeat("x", 3*minReadBuffer+7)
+	name := filepath.Join(t.TempDir(), "long.txt")
+	if err := os.WriteFile(name, []byte("short\r\n"+long+"\r\nend"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatalf("CreateFile() = %v", err)
+	}
+	if f.Lines.Len() != 3 || f.Lines.Line(1) != long || f.Lines.Line(2) != "end" {
+		t.Fatalf("read %d lines, want 3 with the long line intact", f.Lines.Len())
+	}
+	if f.EOL != CRLF || !f.NoFinalNewline {
+		t.Errorf("EOL = %v no final newline %v, want CRLF true", f.EOL, f.NoFinalNewline)
+	}
+
+	f = NewFile(name)
+	f.MaxLineLength = 1024
+	err := f.CreateFile()
+	var tooLong *LineTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Line != 2 {
+		t.Errorf("CreateFile() = %v, want line 2 too long", err)
+	}
+}
+
+func TestLineReaderSplitTerminators(t *testing.T) {
+	lr := newLineReader(iotest.OneByteReader(strings.NewReader("a\r\nb\rc\n\rd")), 0)
+	want := []struct {
+		line string
+		eol  LineEnding
+		ok   bool
+	}{{"a", CRLF, true}, {"b", CR, true}, {"c", LF, true}, {"", CR, true}, {"d", LF, false}}
+
+	for _, w := range want {
+		line, eol, ok, err := lr.next()
+		if err != nil || line != w.line || eol != w.eol || ok != w.ok {
+			t.Fatalf("next() = %q, %v, %v, %v, want %q, %v, %v", line, eol, ok, err, w.line, w.eol, w.ok)
+		}
+	}
+	if _, _, _, err := lr.next(); err != io.EOF {
+		t.Errorf("next() at end = %v, want io.EOF", err)
+	}
+}
+
+func TestUndoRedoGroups(t *testing.T) {
+	f := NewFile("undo.txt")
+	f.BeginChange()
+	f.AppendLine("one")
+	f.AppendLine("two")
+	f.BeginChange()
+	f.UpdateLine("1", "uno")
+	f.BeginChange()
+	f.DeleteLastLine()
+
+	contents := func() string { return strings.Join(f.Lines.Slice(0, f.Lines.Len()), ",") }
+	steps := []struct {
+		op   func() (int, error)
+		want string
+	}{
+		{f.Undo, "uno,two"},
+		{f.Undo, "one,two"},
+		{f.Redo, "uno,two"},
+		{f.Undo, "one,two"},
+		{f.Undo, ""},
+		{f.Redo, "one,two"},
+	}
+	for i, s := range steps {
+		if _, err := s.op(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got := contents(); got != s.want {
+			t.Fatalf("step %d: buffer = %q, want %q", i, got, s.want)
+		}
+	}
+
+	f.BeginChange()
+	f.AppendLine("three")
+	if _, err := f.Redo(); err == nil {
+		t.Error("Redo() after a new change should fail")
+	}
+	if f.Stats().Lines != f.Lines.Len() {
+		t.Errorf("Stats().Lines = %d, want %d", f.Stats().Lines, f.Lines.Len())
+	}
+}
+
+func TestSwapRecovery(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "swap.txt")
+	if err := os.WriteFile(name, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFile(name)
+	f.Swap = true
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	f.BeginChange()
+	f.AppendLine("three")
+	f.UpdateLine("1", "uno")
+	f.Undo()
+	f.BeginChange()
+	// Simulate a crash by dropping the buffer without closing it
+
+	g := NewFile(name)
+	if err := g.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.FindSwap(); !ok {
+		t.Fatal("swap file not found after a crash")
+	}
+	if err := g.Recover(); err != nil {
+		t.Fatalf("Recover() = %v", err)
+	}
+	if got := strings.Join(g.Lines.Slice(0, g.Lines.Len()), ","); got != "one,two" {
+		t.Errorf("recovered %q, want %q", got, "one,two")
+	}
+
+	f.BeginChange()
+	f.AppendLine("four")
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.FindSwap(); ok {
+		t.Error("swap file still exists after saving")
+	}
+}
+
+func TestWriteRefusesExternalChanges(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "ext.txt")
+	if err := os.WriteFile(name, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	f.AppendLine("two")
+
+	if err := os.WriteFile(name, []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteFile(); !errors.Is(err, ErrModified) {
+		t.Fatalf("WriteFile() = %v, want ErrModified", err)
+	}
+
+	old, err := f.Reload(false)
+	if err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+	if old.Len() != 2 || f.Lines.Len() != 1 || f.Lines.Line(0) != "changed" {
+		t.Errorf("Reload() did not pick up the change on disk")
+	}
+	if _, err := f.WriteFile(); err != nil {
+		t.Errorf("WriteFile() after reload = %v", err)
+	}
+
+	// A file that didn't exist yet is watched from when it was created
+	fresh := NewFile(filepath.Join(t.TempDir(), "new.txt"))
+	if err := fresh.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	fresh.AppendLine("mine")
+	if err := os.WriteFile(fresh.Name, []byte("theirs\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.WriteFile(); !errors.Is(err, ErrModified) {
+		t.Errorf("WriteFile() over a new file written by something else = %v, want ErrModified", err)
+	}
+}
+
+func TestLockSecondSession(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "locked.txt")
+	first := NewFile(name)
+	first.Lock = true
+	if err := first.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewFile(name)
+	second.Lock = true
+	var locked *LockError
+	if err := second.CreateFile(); !errors.As(err, &locked) {
+		t.Fatalf("CreateFile() on a locked file = %v, want a LockError", err)
+	}
+	second.ReadOnly = true
+	if err := second.CreateFile(); err != nil {
+		t.Fatalf("CreateFile() read-only = %v", err)
+	}
+	if err := second.AppendLine("x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("AppendLine() on a read-only buffer = %v, want ErrReadOnly", err)
+	}
+
+	first.Close()
+	host, _ := os.Hostname()
+	stale := fmt.Sprintf("%d %s\n", 1<<30, host)
+	if err := os.WriteFile(LockName(name), []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+	third := NewFile(name)
+	third.Lock = true
+	if err := third.CreateFile(); err != nil {
+		t.Errorf("CreateFile() with a stale lock = %v", err)
+	}
+	third.Close()
+	if _, err := os.Stat(LockName(name)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file still exists after Close")
+	}
+
+	// An empty lock file is being written by whoever created it, until it
+	// has been empty for too long
+	if err := os.WriteFile(LockName(name), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fourth := NewFile(name)
+	fourth.Lock = true
+	if err := fourth.CreateFile(); !errors.As(err, &locked) {
+		t.Fatalf("CreateFile() with a new empty lock = %v, want a LockError", err)
+	}
+	old := time.Now().Add(-2 * lockWriteTime)
+	if err := os.Chtimes(LockName(name), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := fourth.CreateFile(); err != nil {
+		t.Errorf("CreateFile() with an old empty lock = %v", err)
+	}
+	fourth.Close()
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	name := LockName(filepath.Join(t.TempDir(), "locked.txt"))
+	if err := os.WriteFile(name, []byte("1 gone\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("1 gone\n")
+
+	// Another session removed the stale lock and took its own first
+	os.Remove(name)
+	if err := os.WriteFile(name, []byte("2 here\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleLock(name, stale, data); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(name); string(data) != "2 here\n" {
+		t.Errorf("the other session's lock is now %q", data)
+	}
+
+	fresh, _ := os.Stat(name)
+	if err := removeStaleLock(name, fresh, []byte("2 here\n")); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 0 {
+		t.Errorf("left %d files behind after removing the lock", len(entries))
+	}
+}
+
+func TestEncodingsRoundTrip(t *testing.T) {
+	utf16le := []byte{0xff, 0xfe}
+	for _, r := range "héllo 😀\r\nwörld\r\n" {
+		for _, u := range utf16.Encode([]rune{r}) {
+			utf16le = append(utf16le, byte(u), byte(u>>8))
+		}
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		enc   Encoding
+		bom   bool
+		first string
+	}{
+		{"utf-8", []byte("héllo\n"), UTF8, false, "héllo"},
+		{"utf-8 bom", []byte("\xef\xbb\xbfhéllo\n"), UTF8, true, "héllo"},
+		{"utf-16le bom", utf16le, UTF16LE, true, "héllo 😀"},
+		{"utf-16be", []byte{0, 'h', 0, 'i', 0, '\n'}, UTF16BE, false, "hi"},
+		{"latin-1", []byte("h\xe9llo\n"), Latin1, false, "héllo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "enc.txt")
+			if err := os.WriteFile(name, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			f := NewFile(name)
+			if err := f.CreateFile(); err != nil {
+				t.Fatal(err)
+			}
+			if f.Encoding != tt.enc || f.BOM != tt.bom {
+				t.Errorf("encoding = %s, want %v bom %v", f.describeEncoding(), tt.enc, tt.bom)
+			}
+			if f.Lines.Line(0) != tt.first {
+				t.Errorf("first line = %q, want %q", f.Lines.Line(0), tt.first)
+			}
+			if _, err := f.WriteFile(); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(name); !bytes.Equal(data, tt.data) {
+				t.Errorf("round trip = %q, want %q", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestBinaryHexMode(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bin")
+	data := []byte("\x00\x01\x02\x03binary\xff\xfe data that runs past one row")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Hex {
+		t.Fatal("binary file not opened in hex mode")
+	}
+	if err := f.AppendLine("text"); !errors.Is(err, ErrHexMode) {
+		t.Errorf("AppendLine() in hex mode = %v, want ErrHexMode", err)
+	}
+	if err := f.PatchBytes(int64(len(data))-1, []byte("!!")); err != nil {
+		t.Fatalf("PatchBytes() = %v", err)
+	}
+	if f.Lines.Len() != hexRowCount(len(data)+1) {
+		t.Errorf("%d rows after patching, want %d", f.Lines.Len(), hexRowCount(len(data)+1))
+	}
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatal(err)
+	}
+	want := append(data[:len(data)-1:len(data)-1], "!!"...)
+	if got, _ := os.ReadFile(name); !bytes.Equal(got, want) {
+		t.Errorf("saved %q, want %q", got, want)
+	}
+
+	// Patches are undone like any other change, the file shrinks back
+	rows := f.Lines.Slice(0, f.Lines.Len())
+	f.BeginChange()
+	if err := f.PatchBytes(0, bytes.Repeat([]byte{'x'}, len(want)+HexRowBytes)); err != nil {
+		t.Fatalf("PatchBytes() = %v", err)
+	}
+	if !f.Modified() || f.Size() != len(want)+HexRowBytes {
+		t.Fatalf("after patching Modified() = %v and Size() = %d", f.Modified(), f.Size())
+	}
+	if _, err := f.Undo(); err != nil {
+		t.Fatalf("Undo() = %v", err)
+	}
+	if f.Modified() || f.Size() != len(want) || !slices.Equal(f.Lines.Slice(0, f.Lines.Len()), rows) {
+		t.Errorf("after undo Modified() = %v and Size() = %d, rows %q", f.Modified(), f.Size(), f.Lines.Slice(0, f.Lines.Len()))
+	}
+	if _, err := f.Redo(); err != nil || !strings.HasSuffix(f.Lines.Line(0), "|xxxxxxxxxxxxxxxx|") {
+		t.Errorf("after redo Redo() = %v, first row %q", err, f.Lines.Line(0))
+	}
+
+	// Reloading can't be undone in hex mode, so it won't throw the patch
+	// away unless forced
+	if _, err := f.Reload(false); !errors.Is(err, ErrUnsavedChanges) {
+		t.Errorf("Reload() with unsaved patches = %v, want ErrUnsavedChanges", err)
+	}
+	if _, err := f.Reload(true); err != nil || f.Size() != len(want) || f.Modified() {
+		t.Errorf("forced Reload() = %v, Size() = %d", err, f.Size())
+	}
+
+	// Restoring a backup isn't on disk until it is saved
+	f.Backup = SimpleBackup
+	f.BeginChange()
+	f.PatchBytes(0, []byte{9})
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.RestoreBackup(name+"~", false); err != nil {
+		t.Fatalf("RestoreBackup() = %v", err)
+	}
+	if !f.Modified() || f.Lines.Line(0)[10:12] != "00" {
+		t.Errorf("after RestoreBackup() Modified() = %v, first row %q", f.Modified(), f.Lines.Line(0))
+	}
+}
+
+func TestBackups(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(name, []byte("v0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	f.Backup = NumberedBackup
+	f.BackupKeep = 2
+	for _, v := range []string{"v1", "v2", "v3"} {
+		f.UpdateLine("1", v)
+		if _, err := f.WriteFile(); err != nil {
+			t.Fatalf("WriteFile() = %v", err)
+		}
+	}
+
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{name + ".~2~", name + ".~3~"}
+	if !slices.Equal(backups, want) {
+		t.Fatalf("Backups() = %v, want %v", backups, want)
+	}
+	fi, err := os.Stat(backups[0])
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("backup mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	f.BeginChange()
+	if err := f.RestoreBackup(backups[0], false); err != nil {
+		t.Fatalf("RestoreBackup() = %v", err)
+	}
+	if f.Lines.Line(0) != "v1" {
+		t.Errorf("restored line = %q, want v1", f.Lines.Line(0))
+	}
+	if _, err := f.Undo(); err != nil || f.Lines.Line(0) != "v3" {
+		t.Errorf("after undo line = %q, want v3 (%v)", f.Lines.Line(0), err)
+	}
+
+	f.Backup = TimestampBackup
+	f.BackupDir = filepath.Join(dir, "backups")
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	stamped, _ := filepath.Glob(filepath.Join(f.BackupDir, "*"))
+	if len(stamped) != 1 {
+		t.Fatalf("timestamped backups = %v, want one", stamped)
+	}
+	data, _ := os.ReadFile(stamped[0])
+	if string(data) != "v3\n" {
+		t.Errorf("timestamped backup = %q, want %q", data, "v3\n")
+	}
+
+	// b.txt.old's backups start with the same prefix as b.txt's but neither
+	// file counts or prunes the other's
+	if err := os.WriteFile(name+".old", []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := NewFile(name + ".old")
+	if err := other.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	other.Backup = TimestampBackup
+	other.BackupDir = f.BackupDir
+	other.BackupKeep = 1
+	if _, err := other.WriteFile(); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	backups, _ = f.Backups()
+	if want := []string{name + ".~2~", name + ".~3~", stamped[0]}; !slices.Equal(backups, want) {
+		t.Errorf("Backups() = %v, want %v", backups, want)
+	}
+	backups, _ = other.Backups()
+	if len(backups) != 1 || backups[0] == stamped[0] {
+		t.Errorf("Backups() of %s = %v, want only its own", other.Name, backups)
+	}
+}
+
+func TestModified(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "mod.txt")
+	if err := os.WriteFile(name, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Modified() {
+		t.Fatal("Modified() right after loading")
+	}
+
+	f.BeginChange()
+	f.AppendLine("two")
+	if !f.Modified() {
+		t.Fatal("Modified() = false after appending")
+	}
+	f.Undo()
+	if f.Modified() {
+		t.Error("Modified() after undoing back to the saved state")
+	}
+	f.Redo()
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Modified() {
+		t.Error("Modified() after saving")
+	}
+
+	// Once the saved state is undone and replaced it can't come back
+	f.Undo()
+	f.BeginChange()
+	f.AppendLine("three")
+	f.Undo()
+	if !f.Modified() {
+		t.Error("Modified() = false when the saved state is gone")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "ro.txt")
+	if err := os.WriteFile(name, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	f.ReadOnly = true
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AppendLine("two"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("AppendLine() = %v, want ErrReadOnly", err)
+	}
+	if err := f.SetLineEnding(CRLF); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("SetLineEnding() = %v, want ErrReadOnly", err)
+	}
+	if _, err := f.WriteRange(name, 0, 1); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteRange() over the file = %v, want ErrReadOnly", err)
+	}
+	copyName := filepath.Join(dir, "copy.txt")
+	if n, err := f.WriteRange(copyName, 0, 1); err != nil || n != 4 {
+		t.Errorf("WriteRange() = %d, %v, want 4 bytes", n, err)
+	}
+	if f.Name != name {
+		t.Errorf("WriteRange() renamed the buffer to %s", f.Name)
+	}
+
+	if err := f.SetReadOnly(false); err != nil {
+		t.Fatalf("SetReadOnly(false) = %v", err)
+	}
+	if err := f.AppendLine("two"); err != nil {
+		t.Errorf("AppendLine() after SetReadOnly(false) = %v", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("permissions don't apply to root")
+	}
+	if err := os.Chmod(name, 0444); err != nil {
+		t.Fatal(err)
+	}
+	g := NewFile(name)
+	if err := g.CreateFile(); err != nil {
+		t.Fatalf("CreateFile() of an unwritable file = %v", err)
+	}
+	if !g.ReadOnly {
+		t.Error("unwritable file was not opened read-only")
+	}
+	if err := g.SetReadOnly(false); err == nil {
+		t.Error("SetReadOnly(false) of an unwritable file succeeded")
+	}
+}
+
+func TestCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "notes.txt.gz")
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	zw.Write([]byte("one\ntwo\n"))
+	zw.Close()
+	if err := os.WriteFile(name, zbuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Compression != Gzip || f.Lines.Len() != 2 || f.Lines.Line(1) != "two" {
+		t.Fatalf("read %v with %d lines", f.Compression, f.Lines.Len())
+	}
+	f.AppendLine("three")
+	if _, err := f.WriteFile(); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	saved, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saved.Close()
+	zr, err := gzip.NewReader(saved)
+	if err != nil {
+		t.Fatalf("saved file is not gzip: %v", err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "one\ntwo\nthree\n" {
+		t.Errorf("saved %q", data)
+	}
+
+	// bzip2 can be read but has to be saved some other way
+	bzName := filepath.Join(dir, "old.txt.bz2")
+	bz, _ := hex.DecodeString("425a6839314159265359a7142b77000002c180001002018480200021800c0238f51b8bb9229c2848538a15bb80")
+	if err := os.WriteFile(bzName, bz, 0644); err != nil {
+		t.Fatal(err)
+	}
+	g := NewFile(bzName)
+	if err := g.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if g.Compression != Bzip2 || g.Lines.Len() != 2 {
+		t.Fatalf("read %v with %d lines", g.Compression, g.Lines.Len())
+	}
+	if _, err := g.WriteFile(); err == nil {
+		t.Error("WriteFile() of a bzip2 file succeeded")
+	}
+	if err := g.SetCompression(NoCompression); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.WriteFile(); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	if data, _ := os.ReadFile(bzName); string(data) != "one\ntwo\n" {
+		t.Errorf("saved %q", data)
+	}
+
+	// Text that only starts like bzip2 is text
+	for _, text := range []string{"BZh\n", "BZh9 is not bzip2\n", "BZh91AY&SX\n"} {
+		if err := os.WriteFile(bzName, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		h := NewFile(bzName)
+		if err := h.CreateFile(); err != nil {
+			t.Errorf("reading %q: %v", text, err)
+			continue
+		}
+		if h.Compression != NoCompression || h.Lines.Line(0) != strings.TrimSuffix(text, "\n") {
+			t.Errorf("read %q as %v %q", text, h.Compression, h.Lines.Line(0))
+		}
+	}
+
+	// An empty bzip2 stream has no blocks
+	empty, _ := hex.DecodeString("425a683917724538509000000000")
+	if sniffCompression(empty) != Bzip2 {
+		t.Error("empty bzip2 stream not recognised")
+	}
+}
+
+func TestWriteRanges(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "crlf.txt")
+	if err := os.WriteFile(name, []byte("one\r\ntwo\r\nthree"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(name)
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	part := filepath.Join(dir, "part.txt")
+	if n, err := f.WriteRange(part, 1, 3); err != nil || n != 10 {
+		t.Fatalf("WriteRange() = %d, %v, want 10 bytes", n, err)
+	}
+	if data, _ := os.ReadFile(part); string(data) != "two\r\nthree" {
+		t.Errorf("range saved as %q", data)
+	}
+	if _, err := f.WriteRange(name, 0, 1); err == nil {
+		t.Error("WriteRange() of part of the buffer over its own file succeeded")
+	}
+
+	if n, err := f.AppendRange(part, 0, 1); err != nil || n != 5 {
+		t.Fatalf("AppendRange() = %d, %v, want 5 bytes", n, err)
+	}
+	if data, _ := os.ReadFile(part); string(data) != "two\r\nthreeone\r\n" {
+		t.Errorf("after appending %q", data)
+	}
+	if f.Name != name || f.Modified() {
+		t.Errorf("writing a range changed the buffer to %s, modified %v", f.Name, f.Modified())
+	}
+
+	f.AppendLine("four")
+	moved := filepath.Join(dir, "moved.txt")
+	if _, err := f.SaveAs(moved); err != nil {
+		t.Fatalf("SaveAs() = %v", err)
+	}
+	if f.Name != moved || f.Modified() {
+		t.Errorf("SaveAs() left the buffer as %s, modified %v", f.Name, f.Modified())
+	}
+	if data, _ := os.ReadFile(moved); string(data) != "one\r\ntwo\r\nthree\r\nfour" {
+		t.Errorf("saved as %q", data)
+	}
+	if data, _ := os.ReadFile(name); string(data) != "one\r\ntwo\r\nthree" {
+		t.Errorf("SaveAs() changed the old file to %q", data)
+	}
+	if _, err := os.Stat(LockName(name)); err == nil {
+		t.Error("the old file is still locked")
+	}
+
+	// Appending to the file being edited isn't a change made by something
+	// else, but doesn't hide one either
+	if _, err := f.AppendRange(moved, 0, 1); err != nil {
+		t.Fatalf("AppendRange() = %v", err)
+	}
+	if _, err := f.WriteFile(); err != nil {
+		t.Errorf("WriteFile() after appending to its own file = %v", err)
+	}
+	if err := os.WriteFile(moved, []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.AppendRange(moved, 0, 1); err != nil {
+		t.Fatalf("AppendRange() = %v", err)
+	}
+	if _, err := f.WriteFile(); !errors.Is(err, ErrModified) {
+		t.Errorf("WriteFile() after a change on disk = %v, want ErrModified", err)
+	}
+}
+
+func TestInsertFile(t *testing.T) {
+	dir := t.TempDir()
+	snippet := filepath.Join(dir, "snippet.txt")
+	if err := os.WriteFile(snippet, []byte("two words\r\nthree\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile(filepath.Join(dir, "doc.txt"))
+	if err := f.CreateFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.AppendLine("one")
+	f.AppendLine("four")
+
+	f.BeginChange()
+	if n, err := f.InsertFile(1, snippet); err != nil || n != 2 {
+		t.Fatalf("InsertFile() = %d, %v, want 2 lines", n, err)
+	}
+	if got := f.Lines.Slice(0, f.Lines.Len()); !slices.Equal(got, []string{"one", "two words", "three", "four"}) {
+		t.Errorf("after InsertFile() lines = %q", got)
+	}
+	if s := f.Stats(); s.Lines != 4 || s.Words != 5 {
+		t.Errorf("Stats() = %d lines %d words, want 4 and 5", s.Lines, s.Words)
+	}
+
+	f.BeginChange()
+	if n, err := f.InsertFrom(0, strings.NewReader("zero\n")); err != nil || n != 1 {
+		t.Fatalf("InsertFrom() = %d, %v, want 1 line", n, err)
+	}
+	if f.Lines.Line(0) != "zero" {
+		t.Errorf("first line = %q", f.Lines.Line(0))
+	}
+
+	f.Undo()
+	f.Undo()
+	if got := f.Lines.Slice(0, f.Lines.Len()); !slices.Equal(got, []string{"one", "four"}) {
+		t.Errorf("after undoing the inserts lines = %q", got)
+	}
+	if _, err := f.InsertFile(0, filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("InsertFile() of a missing file succeeded")
+	}
+}
+
+func TestMarksFollowLines(t *testing.T) {
+	f := NewFile("marks.txt")
+	for i := 0; i < 10; i++ {
+		f.AppendLine(fmt.Sprint(i))
+	}
+	f.SetMarks([]int{2, 4, 6, 8})
+
+	if line, _ := f.NextMark(); line != 2 {
+		t.Fatalf("first mark is line %d, want 2", line)
+	}
+	f.ReplaceLines(2, 3)            // the line just visited, the marks move up to 3 5 7
+	f.ReplaceLines(0, 0, "a", "b")  // and down by two to 5 7 9
+	f.ReplaceLines(7, 8, "changed") // replaced in place, the mark stays
+	f.ReplaceLines(9, 10)           // the mark goes with the line
+	f.ReplaceLines(0, 1)            // 5 7 move up to 4 6
+
+	var got []int
+	for line, ok := f.NextMark(); ok; line, ok = f.NextMark() {
+		got = append(got, line)
+	}
+	if !slices.Equal(got, []int{4, 6}) {
+		t.Errorf("marks ended up on %v, want [4 6]", got)
+	}
+}
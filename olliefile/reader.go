@@ -0,0 +1,130 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Initial size of the read buffer, it only grows past this to hold a single
+// line that doesn't fit.
+const minReadBuffer = 64 * 1024
+
+// LineTooLongError is returned when reading a file with a line longer than
+// File.MaxLineLength
+type LineTooLongError struct {
+	Line int
+	Max  int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("line %d is longer than the limit of %d bytes", e.Line, e.Max)
+}
+
+// lineReader splits its input on "\n", "\r\n" or "\r" and, unlike
+// bufio.Scanner, handles lines of any length. Each line is searched for a
+// terminator once and copied out of the read buffer once.
+type lineReader struct {
+	rd      io.Reader
+	buf     []byte
+	start   int // start of the current line in buf
+	scanned int // bytes of the current line already searched for a terminator
+	end     int // end of the data read into buf
+	err     error
+	max     int // longest line allowed in bytes, 0 for no limit
+	line    int // number of lines returned so far
+}
+
+func newLineReader(rd io.Reader, max int) *lineReader {
+	return &lineReader{rd: rd, max: max}
+}
+
+// next returns the next line without its terminator and which terminator it
+// had. terminated is false for a last line that doesn't end in a newline.
+// io.EOF is returned once every line has been read.
+func (lr *lineReader) next() (line string, eol LineEnding, terminated bool, err error) {
+	for {
+		data := lr.buf[lr.start:lr.end]
+		if i := bytes.IndexAny(data[lr.scanned:], "\r\n"); i >= 0 {
+			i += lr.scanned
+			n, eol := 1, LF
+			if data[i] == '\r' {
+				eol = CR
+				if i+1 < len(data) && data[i+1] == '\n' {
+					n, eol = 2, CRLF
+				}
+			}
+
+			// A '\r' at the end of what we have read so far could be the first
+			// half of "\r\n", so read more before deciding.
+			if eol != CR || i+1 < len(data) || lr.err != nil {
+				if lr.max > 0 && i > lr.max {
+					return "", LF, false, &LineTooLongError{Line: lr.line + 1, Max: lr.max}
+				}
+				lr.start += i + n
+				lr.scanned = 0
+				lr.line += 1
+				return string(data[:i]), eol, true, nil
+			}
+			lr.scanned = i
+		} else {
+			lr.scanned = len(data)
+		}
+
+		if lr.max > 0 && lr.scanned > lr.max {
+			return "", LF, false, &LineTooLongError{Line: lr.line + 1, Max: lr.max}
+		}
+
+		if lr.err != nil {
+			if !errors.Is(lr.err, io.EOF) || len(data) == 0 {
+				return "", LF, false, lr.err
+			}
+			lr.start = lr.end
+			lr.scanned = 0
+			lr.line += 1
+			return string(data), LF, false, nil
+		}
+		lr.fill()
+	}
+}
+
+// fill moves the current line to the front of the buffer, growing it if the
+// line already fills it, and reads more data after it.
+func (lr *lineReader) fill() {
+	if lr.start > 0 {
+		lr.end = copy(lr.buf, lr.buf[lr.start:lr.end])
+		lr.start = 0
+	}
+
+	if lr.end == len(lr.buf) {
+		buf := make([]byte, max(2*len(lr.buf), minReadBuffer))
+		copy(buf, lr.buf[:lr.end])
+		lr.buf = buf
+	}
+
+	n, err := lr.rd.Read(lr.buf[lr.end:])
+	lr.end += n
+	lr.err = err
+}
@@ -0,0 +1,74 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writable reports whether we can save name. Saving replaces the file with a
+// new one, so the directory has to be writable as well as the file itself.
+func writable(name string) bool {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+
+	target, err := resolveTarget(name)
+	if err != nil {
+		return false
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return false
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return true
+}
+
+// SetReadOnly makes the buffer read-only or lets it be changed again. Making
+// it writable takes the lock on the file and fails if someone else holds it
+// or the file can't be saved.
+func (o *File) SetReadOnly(readOnly bool) error {
+	if readOnly || !o.ReadOnly {
+		o.ReadOnly = readOnly
+		return nil
+	}
+
+	if o.Name != "" && !writable(o.Name) {
+		return fmt.Errorf("%s is not writable", o.Name)
+	}
+	if o.Lock && o.lockName == "" {
+		err := o.lock()
+		if err != nil {
+			return err
+		}
+	}
+	o.ReadOnly = false
+	return nil
+}
@@ -0,0 +1,235 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import "slices"
+
+// Buffer holds the lines of a file. Lines are numbered from 0 and ranges are
+// half open, so Delete(2, 4) removes the third and fourth lines. Like slices,
+// out of range arguments panic, callers are expected to check them first.
+type Buffer interface {
+	Len() int
+	Line(i int) string
+	Slice(from, to int) []string
+
+	// Range calls fn for each line in [from, to) in order until fn returns
+	// false. It is cheaper than calling Line for every line.
+	Range(from, to int, fn func(i int, line string) bool)
+
+	Insert(at int, lines ...string)
+	Delete(from, to int)
+	Replace(from, to int, lines ...string)
+
+	// Snapshot returns a copy of the buffer that is not affected by later
+	// edits to either of them
+	Snapshot() Buffer
+}
+
+// Most lines a single leaf of the rope holds
+const maxLeaf = 128
+
+// Rope is a Buffer stored as a balanced (AVL) tree of line chunks, which
+// makes inserting, deleting and replacing ranges O(log n) no matter how long
+// the file is.
+//
+// Nodes are never modified once created, an edit builds new nodes along the
+// path it touches and shares everything else. That makes a snapshot nothing
+// more than a copy of the root pointer.
+type Rope struct {
+	root *node
+}
+
+type node struct {
+	left, right *node
+	lines       []string // only set on leaves
+	count       int      // lines in this subtree
+	height      int      // leaves are 0
+}
+
+var _ Buffer = (*Rope)(nil)
+
+// NewRope returns a rope holding a copy of lines
+func NewRope(lines ...string) *Rope {
+	return &Rope{root: build(slices.Clone(lines))}
+}
+
+func (r *Rope) Len() int {
+	if r.root == nil {
+		return 0
+	}
+	return r.root.count
+}
+
+func (r *Rope) Line(i int) string {
+	if i < 0 || i >= r.Len() {
+		panic("olliefile: line index out of range")
+	}
+	n := r.root
+	for n.lines == nil {
+		if i < n.left.count {
+			n = n.left
+		} else {
+			i -= n.left.count
+			n = n.right
+		}
+	}
+	return n.lines[i]
+}
+
+func (r *Rope) Slice(from, to int) []string {
+	lines := make([]string, 0, to-from)
+	r.Range(from, to, func(_ int, line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	return lines
+}
+
+func (r *Rope) Range(from, to int, fn func(i int, line string) bool) {
+	r.checkRange(from, to)
+	walk(r.root, 0, from, to, fn)
+}
+
+func (r *Rope) Insert(at int, lines ...string) {
+	r.Replace(at, at, lines...)
+}
+
+func (r *Rope) Delete(from, to int) {
+	r.Replace(from, to)
+}
+
+func (r *Rope) Replace(from, to int, lines ...string) {
+	r.checkRange(from, to)
+	left, rest := split(r.root, from)
+	_, right := split(rest, to-from)
+	r.root = join(join(left, build(slices.Clone(lines))), right)
+}
+
+func (r *Rope) Snapshot() Buffer {
+	return &Rope{root: r.root}
+}
+
+func (r *Rope) checkRange(from, to int) {
+	if from < 0 || to < from || to > r.Len() {
+		panic("olliefile: line range out of range")
+	}
+}
+
+// walk calls fn for the lines of n in [from, to). offset is the index of the
+// first line of n in the whole rope.
+func walk(n *node, offset, from, to int, fn func(int, string) bool) bool {
+	if n == nil || to <= offset || from >= offset+n.count {
+		return true
+	}
+	if n.lines != nil {
+		for i := max(from-offset, 0); i < min(to-offset, len(n.lines)); i++ {
+			if !fn(offset+i, n.lines[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return walk(n.left, offset, from, to, fn) &&
+		walk(n.right, offset+n.left.count, from, to, fn)
+}
+
+func leaf(lines []string) *node {
+	if len(lines) == 0 {
+		return nil
+	}
+	return &node{lines: lines, count: len(lines)}
+}
+
+func branch(left, right *node) *node {
+	return &node{
+		left:   left,
+		right:  right,
+		count:  left.count + right.count,
+		height: max(left.height, right.height) + 1,
+	}
+}
+
+// build returns a balanced tree holding lines, which it takes ownership of
+func build(lines []string) *node {
+	if len(lines) <= maxLeaf {
+		return leaf(lines)
+	}
+	mid := len(lines) / 2
+	return branch(build(lines[:mid:mid]), build(lines[mid:]))
+}
+
+// split returns trees holding the first at lines of n and the rest
+func split(n *node, at int) (*node, *node) {
+	switch {
+	case n == nil:
+		return nil, nil
+	case at <= 0:
+		return nil, n
+	case at >= n.count:
+		return n, nil
+	case n.lines != nil:
+		return leaf(n.lines[:at:at]), leaf(n.lines[at:])
+	case at < n.left.count:
+		l, r := split(n.left, at)
+		return l, join(r, n.right)
+	case at > n.left.count:
+		l, r := split(n.right, at-n.left.count)
+		return join(n.left, l), r
+	}
+	return n.left, n.right
+}
+
+// join concatenates two trees, keeping the result balanced. Small adjacent
+// leaves are merged so repeated edits don't leave the tree full of tiny ones.
+func join(a, b *node) *node {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.height > b.height+1:
+		return rebalance(a.left, join(a.right, b))
+	case b.height > a.height+1:
+		return rebalance(join(a, b.left), b.right)
+	case a.lines != nil && b.lines != nil && a.count+b.count <= maxLeaf:
+		return leaf(slices.Concat(a.lines, b.lines))
+	}
+	return branch(a, b)
+}
+
+// rebalance joins two balanced trees whose heights differ by at most two,
+// rotating when they differ by two.
+func rebalance(l, r *node) *node {
+	switch {
+	case l.height > r.height+1:
+		if l.left.height >= l.right.height {
+			return branch(l.left, branch(l.right, r))
+		}
+		return branch(branch(l.left, l.right.left), branch(l.right.right, r))
+	case r.height > l.height+1:
+		if r.right.height >= r.left.height {
+			return branch(branch(l, r.left), r.right)
+		}
+		return branch(branch(l, r.left.left), branch(r.left.right, r.right))
+	}
+	return branch(l, r)
+}
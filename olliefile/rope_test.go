@@ -0,0 +1,82 @@
+package olliefile
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// checkNode verifies the AVL and count invariants of n and returns its height
+func checkNode(t *testing.T, n *node) int {
+	t.Helper()
+	if n == nil {
+		return -1
+	}
+	if n.lines != nil {
+		if n.count != len(n.lines) || n.height != 0 {
+			t.Fatalf("bad leaf: count %d height %d with %d lines", n.count, n.height, len(n.lines))
+		}
+		return 0
+	}
+	lh, rh := checkNode(t, n.left), checkNode(t, n.right)
+	if n.left == nil || n.right == nil {
+		t.Fatal("branch with a nil child")
+	}
+	if lh-rh > 1 || rh-lh > 1 {
+		t.Fatalf("unbalanced node: heights %d and %d", lh, rh)
+	}
+	if n.count != n.left.count+n.right.count || n.height != max(lh, rh)+1 {
+		t.Fatalf("bad branch: count %d height %d", n.count, n.height)
+	}
+	return n.height
+}
+
+func TestRopeMatchesSlice(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var want []string
+	rope := NewRope()
+	var snap Buffer
+	var snapWant []string
+
+	for step := 0; step < 3000; step++ {
+		from := rng.Intn(len(want) + 1)
+		to := from + rng.Intn(len(want)-from+1)
+		lines := make([]string, rng.Intn(300))
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%d.%d", step, i)
+		}
+
+		switch rng.Intn(3) {
+		case 0:
+			rope.Insert(from, lines...)
+			want = slices.Insert(want, from, lines...)
+		case 1:
+			rope.Delete(from, to)
+			want = slices.Delete(want, from, to)
+		case 2:
+			rope.Replace(from, to, lines...)
+			want = slices.Replace(want, from, to, lines...)
+		}
+		if step == 1000 {
+			snap, snapWant = rope.Snapshot(), slices.Clone(want)
+		}
+
+		checkNode(t, rope.root)
+		if rope.Len() != len(want) {
+			t.Fatalf("step %d: Len() = %d, want %d", step, rope.Len(), len(want))
+		}
+	}
+
+	if got := rope.Slice(0, rope.Len()); !slices.Equal(got, want) {
+		t.Fatal("rope contents differ from slice")
+	}
+	for i := range want {
+		if rope.Line(i) != want[i] {
+			t.Fatalf("Line(%d) = %q, want %q", i, rope.Line(i), want[i])
+		}
+	}
+	if got := snap.Slice(0, snap.Len()); !slices.Equal(got, snapWant) {
+		t.Fatal("snapshot changed after later edits")
+	}
+}
@@ -0,0 +1,134 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Stats counts what is in a buffer. It is kept up to date as lines are added
+// and removed instead of being worked out from the whole buffer again.
+//
+// Runes and Bytes count the text of the lines in UTF-8, line endings aren't
+// included since they depend on how the file is saved. A blank line is empty
+// or holds only spaces.
+type Stats struct {
+	Lines   int
+	Words   int
+	Runes   int
+	Bytes   int
+	Blank   int
+	Longest int // runes in the longest line
+
+	lengths map[int]int // how many lines there are of each length in runes
+}
+
+// statsOf counts the lines of a whole buffer
+func statsOf(buf Buffer) Stats {
+	var s Stats
+	buf.Range(0, buf.Len(), func(_ int, line string) bool {
+		s.add(line)
+		return true
+	})
+	return s
+}
+
+// add counts a line that was added to the buffer
+func (s *Stats) add(line string) {
+	s.update(line, 1)
+}
+
+// remove uncounts a line that was removed from the buffer
+func (s *Stats) remove(line string) {
+	s.update(line, -1)
+}
+
+func (s *Stats) update(line string, sign int) {
+	runes := utf8.RuneCountInString(line)
+	s.Lines += sign
+	s.Words += sign * countWords(line)
+	s.Runes += sign * runes
+	s.Bytes += sign * len(line)
+	if strings.TrimSpace(line) == "" {
+		s.Blank += sign
+	}
+	s.count(runes, sign)
+}
+
+// count changes the number of lines of length n and keeps Longest right. When
+// the last of the longest lines goes away the next longest is looked for
+// among the lengths there are, not among the lines.
+func (s *Stats) count(n, delta int) {
+	if s.lengths == nil {
+		s.lengths = make(map[int]int)
+	}
+	s.lengths[n] += delta
+	if s.lengths[n] <= 0 {
+		delete(s.lengths, n)
+	}
+
+	switch {
+	case delta > 0 && n > s.Longest:
+		s.Longest = n
+	case delta < 0 && n == s.Longest && s.lengths[n] == 0:
+		s.Longest = 0
+		for l := range s.lengths {
+			s.Longest = max(s.Longest, l)
+		}
+	}
+}
+
+// merge adds the counts in other to s
+func (s *Stats) merge(other Stats) {
+	s.Lines += other.Lines
+	s.Words += other.Words
+	s.Runes += other.Runes
+	s.Bytes += other.Bytes
+	s.Blank += other.Blank
+	for n, c := range other.lengths {
+		s.count(n, c)
+	}
+}
+
+// countWords counts the runs of non-space characters in line, the same
+// words strings.Fields would return
+func countWords(line string) int {
+	words := 0
+	inWord := false
+	for _, r := range line {
+		if unicode.IsSpace(r) {
+			inWord = false
+		} else if !inWord {
+			words += 1
+			inWord = true
+		}
+	}
+	return words
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("Line Count: %d\nWord Count: %d\nCharacters: %d\nBytes: %d\nLongest Line: %d\nBlank Lines: %d",
+		s.Lines, s.Words, s.Runes, s.Bytes, s.Longest, s.Blank)
+}
@@ -0,0 +1,74 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestStatsCounts(t *testing.T) {
+	f := NewFile("stats.txt")
+	f.AppendLine("the quick  brown fox")
+	f.AppendLine("")
+	f.AppendLine("   ")
+	f.AppendLine("héllo wörld")
+
+	want := Stats{Lines: 4, Words: 6, Runes: 20 + 3 + 11, Bytes: 20 + 3 + 13, Blank: 2, Longest: 20}
+	if got := f.Stats(); got.String() != want.String() {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	f.DeleteLastLine()
+	f.DeleteLastLine()
+	if s := f.Stats(); s.Lines != 2 || s.Words != 4 || s.Blank != 1 {
+		t.Errorf("after deleting, Stats() = %+v", s)
+	}
+}
+
+// The counts kept up to date edit by edit match counting the buffer again
+func TestStatsIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	f := NewFile("stats.txt")
+	for step := 0; step < 2000; step++ {
+		n := f.Lines.Len()
+		from := rng.Intn(n + 1)
+		to := from + rng.Intn(min(n-from, 5)+1)
+		lines := make([]string, rng.Intn(4))
+		for i := range lines {
+			lines[i] = strings.Repeat(fmt.Sprintf("w%d ", step), rng.Intn(6))
+		}
+		if rng.Intn(10) == 0 {
+			f.BeginChange()
+			f.Undo()
+			continue
+		}
+		f.ReplaceLines(from, to, lines...)
+
+		got, want := f.Stats(), statsOf(f.Lines)
+		if got.String() != want.String() {
+			t.Fatalf("step %d: Stats() = %+v, want %+v", step, got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The swap file is an append-only journal of every change made to a buffer
+// since it was last loaded or saved. It lives next to the file being edited
+// so that if ollie dies before a save the changes can be replayed on top of
+// the file on disk.
+//
+// It holds a swapHeader followed by one swapRecord per change, all JSON.
+
+type swapHeader struct {
+	Name   string    `json:"name"`
+	Pid    int       `json:"pid"`
+	Loaded bool      `json:"loaded"` // false if the buffer didn't start from the file on disk
+	Size   int64     `json:"size"`
+	Mtime  time.Time `json:"mtime"`
+}
+
+type swapRecord struct {
+	At     int      `json:"at"`
+	Delete int      `json:"del"`
+	Insert []string `json:"ins"`
+}
+
+// SwapName returns the name of the swap file kept for the file name
+func SwapName(name string) string {
+	dir, base := filepath.Split(name)
+	return filepath.Join(dir, "."+base+".ollie.swp")
+}
+
+// FindSwap reports whether a swap file left over from an earlier session
+// exists for this file
+func (o *File) FindSwap() (string, bool) {
+	name := SwapName(o.Name)
+	if _, err := os.Stat(name); err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// writeSwap appends c to the swap file, creating it first if this is the
+// first change since the file was loaded or saved. Failing to write the swap
+// file shouldn't stop an edit, so errors turn the swap file off and are
+// reported by String.
+func (o *File) writeSwap(c change) {
+	// The swap file isn't encrypted
+	if !o.Swap || o.ReadOnly || o.swapErr != nil || o.crypt != nil {
+		return
+	}
+
+	if o.swap == nil {
+		err := o.createSwap()
+		if err != nil {
+			o.swapErr = err
+			return
+		}
+	}
+
+	rec, err := json.Marshal(swapRecord{At: c.at, Delete: len(c.old), Insert: c.new})
+	if err == nil {
+		_, err = o.swap.Write(append(rec, '\n'))
+	}
+	if err != nil {
+		o.swapErr = err
+		o.closeSwap()
+	}
+}
+
+func (o *File) createSwap() error {
+	hdr := swapHeader{Name: o.Name, Pid: os.Getpid(), Loaded: o.loaded}
+	if fi, err := os.Stat(o.Name); err == nil {
+		hdr.Size = fi.Size()
+		hdr.Mtime = fi.ModTime()
+	}
+	data, err := json.Marshal(hdr)
+	if err != nil {
+		return err
+	}
+
+	o.swapName = SwapName(o.Name)
+	f, err := os.OpenFile(o.swapName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	o.swap = f
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// syncSwap makes sure everything written to the swap file so far is on disk
+func (o *File) syncSwap() {
+	if o.swap != nil {
+		o.swap.Sync()
+	}
+}
+
+func (o *File) closeSwap() {
+	if o.swap != nil {
+		o.swap.Close()
+		o.swap = nil
+	}
+}
+
+// removeSwap deletes the swap file, which is done once the buffer has been
+// saved and the changes in it are on disk
+func (o *File) removeSwap() {
+	if o.swap != nil {
+		o.closeSwap()
+		os.Remove(o.swapName)
+	}
+}
+
+// ReadSwap replays the swap file left by an earlier session on top of the
+// buffer and returns the result, the buffer itself is not changed. It should
+// be called right after CreateFile while the buffer still matches the disk.
+//
+// A swap file cut short by a crash is replayed as far as it goes. warning is
+// set when the file on disk changed after the swap file was started, in which
+// case the result may not be what was being edited.
+func (o *File) ReadSwap() (buf Buffer, warning string, err error) {
+	f, err := os.Open(SwapName(o.Name))
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	var hdr swapHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return nil, "", fmt.Errorf("read swap file header: %w", err)
+	}
+
+	buf = NewRope()
+	if hdr.Loaded {
+		buf = o.Lines.Snapshot()
+		fi, err := os.Stat(o.Name)
+		if err != nil || fi.Size() != hdr.Size || !fi.ModTime().Equal(hdr.Mtime) {
+			warning = fmt.Sprintf("%s has changed since the swap file was made", o.Name)
+		}
+	}
+
+	for {
+		var rec swapRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, warning, fmt.Errorf("read swap file: %w", err)
+		}
+		if rec.At < 0 || rec.Delete < 0 || rec.At+rec.Delete > buf.Len() {
+			return nil, warning, fmt.Errorf("swap file does not match %s", o.Name)
+		}
+		buf.Replace(rec.At, rec.At+rec.Delete, rec.Insert...)
+	}
+	return buf, warning, nil
+}
+
+// Recover replaces the buffer with the contents recovered from the swap file.
+// The recovery is a single change, so it can be undone to get back to the
+// file as it is on disk.
+func (o *File) Recover() error {
+	buf, _, err := o.ReadSwap()
+	if err != nil {
+		return err
+	}
+	if err := o.DiscardSwap(); err != nil {
+		return err
+	}
+	return o.ReplaceLines(0, o.Lines.Len(), buf.Slice(0, buf.Len())...)
+}
+
+// DiscardSwap removes the swap file left by an earlier session
+func (o *File) DiscardSwap() error {
+	err := os.Remove(SwapName(o.Name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Close releases the file and removes its swap and lock files. It is called when the
+// editor exits normally.
+func (o *File) Close() error {
+	o.removeSwap()
+	o.unlock()
+	if o.FileHandle != nil {
+		return o.FileHandle.Close()
+	}
+	return nil
+}
+
+// Release releases the file and its lock like Close, but keeps the swap file
+// when there are unsaved changes so they can be recovered. It is called when
+// the editor stops because its input ended, which is what a terminal going
+// away looks like.
+func (o *File) Release() error {
+	if o.Modified() {
+		o.syncSwap()
+		o.closeSwap()
+	} else {
+		o.removeSwap()
+	}
+	o.unlock()
+	if o.FileHandle != nil {
+		return o.FileHandle.Close()
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import "fmt"
+
+// change is a single reversible edit to a buffer. The lines starting at at
+// that held old were replaced by new. The line endings are only recorded for
+// buffers with mixed line endings. In hex mode the bytes are changed instead
+// and at is the first row showing them.
+type change struct {
+	at      int
+	old     []string
+	new     []string
+	oldEnds []string
+	newEnds []string
+	patch   *bytePatch
+}
+
+func (c change) reverse() change {
+	r := change{at: c.at, old: c.new, new: c.old, oldEnds: c.newEnds, newEnds: c.oldEnds}
+	if c.patch != nil {
+		r.patch = c.patch.reverse()
+	}
+	return r
+}
+
+// journal keeps every change made to a buffer grouped by the command that made
+// it, so a whole command can be undone at once. There is no limit on how far
+// back it goes.
+//
+// saved is how many groups were in undo when the buffer was last the same as
+// the file on disk, so undoing back to the saved state makes the buffer clean
+// again. It is -1 once that state can't be reached anymore.
+type journal struct {
+	undo   [][]change
+	redo   [][]change
+	sealed bool // the next change starts a new group
+	saved  int
+}
+
+func (j *journal) record(c change) {
+	if j.sealed || len(j.undo) == 0 {
+		// The saved state was undone and is about to be thrown away with
+		// the redo list
+		if j.saved > len(j.undo) {
+			j.saved = -1
+		}
+		j.undo = append(j.undo, nil)
+		j.sealed = false
+	} else if j.saved == len(j.undo) {
+		j.saved = -1
+	}
+	last := len(j.undo) - 1
+	j.undo[last] = append(j.undo[last], c)
+	j.redo = nil
+}
+
+// markSaved records that the buffer is the same as the file on disk
+func (j *journal) markSaved() {
+	j.saved = len(j.undo)
+	j.sealed = true
+}
+
+// BeginChange marks the start of a new command. Everything changed from now
+// until the next BeginChange is undone and redone together.
+func (o *File) BeginChange() {
+	o.history.sealed = true
+	o.syncSwap()
+}
+
+// Undo reverts the changes made by the last command and returns the index of
+// the first line it touched
+func (o *File) Undo() (int, error) {
+	if o.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	j := &o.history
+	if len(j.undo) == 0 {
+		return 0, fmt.Errorf("nothing to undo")
+	}
+	group := j.undo[len(j.undo)-1]
+	j.undo = j.undo[:len(j.undo)-1]
+	j.redo = append(j.redo, group)
+	j.sealed = true
+
+	for i := len(group) - 1; i >= 0; i-- {
+		o.apply(group[i].reverse())
+	}
+	return group[0].at, nil
+}
+
+// Redo applies the changes of the last undone command again and returns the
+// index of the first line it touched
+func (o *File) Redo() (int, error) {
+	if o.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	j := &o.history
+	if len(j.redo) == 0 {
+		return 0, fmt.Errorf("nothing to redo")
+	}
+	group := j.redo[len(j.redo)-1]
+	j.redo = j.redo[:len(j.redo)-1]
+	j.undo = append(j.undo, group)
+	j.sealed = true
+
+	for _, c := range group {
+		o.apply(c)
+	}
+	return group[0].at, nil
+}
@@ -0,0 +1,195 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package olliefile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// part returns a File holding lines [from, to) of the buffer that saves them
+// the way the buffer would be saved, in the same encoding, line endings and
+// passphrase. Whether it is compressed goes by the name it is written to.
+func (o *File) part(name string, from, to int) (*File, error) {
+	if from < 0 || to < from || to > o.Lines.Len() {
+		return nil, fmt.Errorf("invalid line range %d,%d", from+1, to)
+	}
+
+	p := &File{
+		Name:           name,
+		EOL:            o.EOL,
+		MixedEOL:       o.MixedEOL,
+		NoFinalNewline: o.NoFinalNewline && to == o.Lines.Len(),
+		Encoding:       o.Encoding,
+		BOM:            o.BOM,
+		Compression:    compressionFor(name),
+		crypt:          o.crypt,
+		Hex:            o.Hex,
+	}
+	if o.Hex {
+		p.raw = o.raw[min(from*HexRowBytes, len(o.raw)):min(to*HexRowBytes, len(o.raw))]
+		return p, nil
+	}
+
+	// Snapshots are cheap for both buffers and keep a mapped file mapped
+	p.Lines = o.Lines.Snapshot()
+	p.Lines.Delete(to, p.Lines.Len())
+	p.Lines.Delete(0, from)
+	if o.lineEnds != nil {
+		p.lineEnds = o.lineEnds.Snapshot()
+		p.lineEnds.Delete(to, p.lineEnds.Len())
+		p.lineEnds.Delete(0, from)
+	}
+	return p, nil
+}
+
+// isOwnFile reports whether name is the file being edited
+func (o *File) isOwnFile(name string) bool {
+	if name == o.Name {
+		return true
+	}
+	a, aerr := os.Stat(o.Name)
+	b, berr := os.Stat(name)
+	return aerr == nil && berr == nil && os.SameFile(a, b)
+}
+
+// WriteRange saves lines [from, to) of the buffer to another file, leaving
+// the buffer and o.Name as they are. Writing every line makes a copy of the
+// file, or saves it when name is the file being edited. Only part of the
+// buffer can't be written over its own file. A read-only buffer can be
+// written anywhere else.
+func (o *File) WriteRange(name string, from, to int) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("no file name specified")
+	}
+	if o.isOwnFile(name) {
+		if o.ReadOnly {
+			return 0, ErrReadOnly
+		}
+		if from != 0 || to != o.Lines.Len() {
+			return 0, fmt.Errorf("%s is the file being edited, only all of it can be written there", name)
+		}
+		return o.WriteFile()
+	}
+	p, err := o.part(name, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	_, bytes, err := writeAtomic(name, func(w io.Writer) (int, error) {
+		cw := &countingWriter{w: w}
+		err := p.save(cw)
+		return cw.n, err
+	})
+	return bytes, err
+}
+
+// AppendRange adds lines [from, to) of the buffer to the end of the file
+// name, creating it if needed. A byte order mark is only written to an empty
+// file. Appending to a compressed file adds another gzip member, which
+// readers treat as one stream. Encrypted text can't be appended since each
+// file is sealed as a whole. Appending to the file being edited isn't a
+// change made by something else, saving still works afterwards.
+func (o *File) AppendRange(name string, from, to int) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("no file name specified")
+	}
+	if o.ReadOnly && o.isOwnFile(name) {
+		return 0, ErrReadOnly
+	}
+	if o.crypt != nil {
+		return 0, errors.New("encrypted text can't be appended to a file")
+	}
+	p, err := o.part(name, from, to)
+	if err != nil {
+		return 0, err
+	}
+	if p.Compression == Bzip2 {
+		return 0, fmt.Errorf("%s can't be written, bzip2 is only supported for reading", name)
+	}
+	// Changes made by something else before ours are still noticed
+	own := o.isOwnFile(name)
+	changed, _ := o.ChangedOnDisk()
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		p.BOM = false
+	}
+
+	w := bufio.NewWriter(f)
+	cw := &countingWriter{w: w}
+	err = p.save(cw)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && own && !changed && o.disk.name != "" {
+		sum, herr := hashFile(o.Name)
+		if herr == nil {
+			err = o.disk.record(o.Name, sum[:])
+		}
+	}
+	return cw.n, err
+}
+
+// SaveAs renames the buffer to name and saves it there, from then on it is
+// the file being edited. The lock and swap file move along with it.
+func (o *File) SaveAs(name string) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("no file name specified")
+	}
+	if o.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if o.isOwnFile(name) {
+		return o.WriteFile()
+	}
+
+	oldName, oldLock := o.Name, o.lockName
+	o.Name, o.lockName = name, ""
+	if o.Lock {
+		if err := o.lock(); err != nil {
+			o.Name, o.lockName = oldName, oldLock
+			return 0, err
+		}
+	}
+
+	bytes, err := o.ForceWriteFile()
+	if err != nil {
+		o.unlock()
+		o.Name, o.lockName = oldName, oldLock
+		return bytes, err
+	}
+	if oldLock != "" {
+		os.Remove(oldLock)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,65 @@
+// MIT License
+//
+// # Copyright (c) 2024 Travis Montoya
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package search
+
+import (
+	"regexp"
+)
+
+// Matcher finds a regular expression in lines of text. Patterns without any
+// special characters are plain text and are found with the Boyer-Moore
+// stringFinder, which is faster than running the regexp.
+type Matcher struct {
+	re      *regexp.Regexp
+	literal *stringFinder
+}
+
+// Compile parses pattern, which uses Go's regexp syntax
+func Compile(pattern string) (*Matcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{re: re}
+	if prefix, complete := re.LiteralPrefix(); complete && prefix != "" {
+		m.literal = MakeStringFinder(prefix)
+	}
+	return m, nil
+}
+
+// MatchString reports whether the pattern is found in s
+func (m *Matcher) MatchString(s string) bool {
+	if m.literal != nil {
+		return m.literal.Next(s) >= 0
+	}
+	return m.re.MatchString(s)
+}
+
+// Regexp returns the compiled pattern
+func (m *Matcher) Regexp() *regexp.Regexp {
+	return m.re
+}
+
+// String returns the pattern the Matcher was compiled from
+func (m *Matcher) String() string {
+	return m.re.String()
+}